@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/mritd/goadmission/pkg/certs"
+)
+
+var certsFlags = struct {
+	services   []string
+	namespace  string
+	hosts      []string
+	caValidity time.Duration
+	validity   time.Duration
+	output     string
+	dir        string
+	secret     string
+	caFile     string
+	caKeyFile  string
+	certFile   string
+	keyFile    string
+}{}
+
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "TLS certificate tools",
+}
+
+var certsGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a CA and a serving certificate of the webhook services",
+	Long: `Generate a CA and a serving certificate of the webhook services, the base64 CA bundle
+is printed to stdout, it is the caBundle of the webhook configurations.
+
+With --output=files the CA, the CA key, the certificate and the key are written to --dir.
+With --output=secret a Secret manifest of the certificate, the key and the CA is written
+to --dir, the CA key is discarded.`,
+	Example: `  goadmission certs generate --service mutating-webhook --namespace kube-addons
+  CA_BUNDLE=$(goadmission certs generate --service mutating-webhook,validating-webhook --namespace kube-addons --output secret)`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := certsFlags
+		if len(f.services) == 0 {
+			return fmt.Errorf("--service is required")
+		}
+		if f.output != "files" && f.output != "secret" {
+			return fmt.Errorf("unsupported output: %s", f.output)
+		}
+
+		var hosts []string
+		for _, svc := range f.services {
+			hosts = append(hosts, certs.ServiceHosts(svc, f.namespace)...)
+		}
+		hosts = append(hosts, f.hosts...)
+
+		ca, err := certs.GenerateCA(f.caValidity)
+		if err != nil {
+			return err
+		}
+		serving, err := certs.GenerateServing(ca, hosts, f.validity)
+		if err != nil {
+			return err
+		}
+
+		if err = os.MkdirAll(f.dir, 0o755); err != nil {
+			return err
+		}
+		type file struct {
+			name string
+			data []byte
+		}
+		var files []file
+		if f.output == "files" {
+			files = []file{{f.caFile, ca.Cert}, {f.caKeyFile, ca.Key}, {f.certFile, serving.Cert}, {f.keyFile, serving.Key}}
+		} else {
+			secret := &corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: f.secret, Namespace: f.namespace},
+				Type:       corev1.SecretTypeOpaque,
+				Data: map[string][]byte{
+					f.caFile:   ca.Cert,
+					f.certFile: serving.Cert,
+					f.keyFile:  serving.Key,
+				},
+			}
+			bs, err := yaml.Marshal(secret)
+			if err != nil {
+				return err
+			}
+			files = []file{{f.secret + ".yaml", bs}}
+		}
+		for _, file := range files {
+			p := filepath.Join(f.dir, file.name)
+			if err = os.WriteFile(p, file.data, 0o600); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "write %s\n", p)
+		}
+
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), ca.CABundle())
+		return nil
+	},
+}
+
+func init() {
+	certsGenerateCmd.Flags().StringSliceVar(&certsFlags.services, "service", nil, "Webhook service names, the service DNS names are added to the certificate")
+	certsGenerateCmd.Flags().StringVarP(&certsFlags.namespace, "namespace", "n", "kube-addons", "Webhook service namespace")
+	certsGenerateCmd.Flags().StringSliceVar(&certsFlags.hosts, "host", []string{"127.0.0.1", "localhost"}, "Extra DNS names or IP addresses of the certificate")
+	certsGenerateCmd.Flags().DurationVar(&certsFlags.caValidity, "ca-validity", certs.DefaultCAValidity, "CA validity")
+	certsGenerateCmd.Flags().DurationVar(&certsFlags.validity, "validity", certs.DefaultValidity, "Serving certificate validity")
+	certsGenerateCmd.Flags().StringVarP(&certsFlags.output, "output", "o", "files", "Output format ('files' or 'secret')")
+	certsGenerateCmd.Flags().StringVar(&certsFlags.dir, "dir", ".", "Output directory")
+	certsGenerateCmd.Flags().StringVar(&certsFlags.secret, "secret", "dynamic-admission-control-certs", "Secret name of --output=secret")
+	certsGenerateCmd.Flags().StringVar(&certsFlags.caFile, "ca-file", "dac-ca.pem", "CA file name, it is also the Secret key")
+	certsGenerateCmd.Flags().StringVar(&certsFlags.caKeyFile, "ca-key-file", "dac-ca-key.pem", "CA key file name")
+	certsGenerateCmd.Flags().StringVar(&certsFlags.certFile, "cert-file", "dac.pem", "Certificate file name, it is also the Secret key")
+	certsGenerateCmd.Flags().StringVar(&certsFlags.keyFile, "key-file", "dac-key.pem", "Key file name, it is also the Secret key")
+
+	certsCmd.AddCommand(certsGenerateCmd)
+	rootCmd.AddCommand(certsCmd)
+}
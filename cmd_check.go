@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/manifests"
+)
+
+var checkFlags offlineFlags
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Run the validating admission funcs on manifests",
+	Long: `Run the validating admission funcs on manifests, every object is wrapped in a synthetic
+admission request and reviewed as the webhook does. It exits non-zero on any denial.`,
+	Example: `  goadmission check -f ./k8s/ --operation CREATE --user alice
+  kustomize build . | goadmission check -f - --funcs check-deploy-time`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := checkFlags
+		if len(f.files) == 0 {
+			return fmt.Errorf("-f is required")
+		}
+		op, err := f.op()
+		if err != nil {
+			return err
+		}
+		d, err := offlineDispatcher(cmd)
+		if err != nil {
+			return err
+		}
+		paths, err := d.FuncPaths(adfunc.AdmissionTypeValidating, f.funcs)
+		if err != nil {
+			return err
+		}
+		objs, err := manifests.ReadFiles(f.files, cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		var denied int
+		for _, obj := range objs {
+			request, err := adfunc.NewRequest(obj, op, f.userInfo())
+			if err != nil {
+				return err
+			}
+			for _, r := range d.Review(cmd.Context(), paths, request) {
+				switch {
+				case !r.InScope && r.Err == nil:
+					continue
+				case r.Err != nil:
+					denied++
+					_, _ = fmt.Fprintf(out, "deny\t%s\t%s: %v\n", objectName(obj), r.Path, r.Err)
+				case !r.Response.Allowed:
+					denied++
+					_, _ = fmt.Fprintf(out, "deny\t%s\t%s: %s\n", objectName(obj), r.Path, message(r.Response))
+				default:
+					_, _ = fmt.Fprintf(out, "allow\t%s\t%s: %s\n", objectName(obj), r.Path, message(r.Response))
+				}
+				if r.Response != nil {
+					for _, w := range r.Response.Warnings {
+						_, _ = fmt.Fprintf(out, "warn\t%s\t%s: %s\n", objectName(obj), r.Path, w)
+					}
+				}
+			}
+		}
+		if denied > 0 {
+			return fmt.Errorf("%d denial(s) found", denied)
+		}
+		return nil
+	},
+}
+
+func init() {
+	checkFlags.register(checkCmd, "Validating admission funcs to run in order, e.g. check-deploy-time, all validating funcs by default")
+	rootCmd.AddCommand(checkCmd)
+}
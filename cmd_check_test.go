@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkManifests is a Deployment checked by /validating/check-deploy-time, the force
+// deploy label is added by "<force-label>"
+const checkManifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+  labels:
+    app: nginx<force-label>
+spec:
+  selector:
+    matchLabels:
+      app: nginx
+  template:
+    metadata:
+      labels:
+        app: nginx
+    spec:
+      containers:
+      - name: nginx
+        image: nginx:1.21
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+`
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		forceLabel string
+		denied     bool
+		out        string
+	}{
+		{"denied", "", true, "deny\tDeployment default/nginx\t/validating/check-deploy-time: "},
+		{"allowed", "\n    force-deploy.mritd.com: \"true\"", false, "allow\tDeployment default/nginx\t/validating/check-deploy-time: success"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := strings.Replace(checkManifests, "<force-label>", tt.forceLabel, 1)
+			// no time is in the deploy time window
+			stdout, stderr, err := execute(t, stdin, "check", "-f", "-", "--funcs", "check-deploy-time", "--allow-deploy-time", "00:00~00:00")
+			if tt.denied {
+				if err == nil || err.Error() != "1 denial(s) found" {
+					t.Errorf("err = %v, want the denial", err)
+				}
+			} else if err != nil {
+				t.Errorf("err = %v, want nil: %s", err, stderr)
+			}
+			if !strings.HasPrefix(stdout, tt.out) {
+				t.Errorf("output = %q, want %q", stdout, tt.out)
+			}
+			if strings.Contains(stdout, "ConfigMap") {
+				t.Errorf("output = %q, want the ConfigMap out of scope skipped", stdout)
+			}
+		})
+	}
+}
+
+func TestCheckUnknownFunc(t *testing.T) {
+	if _, _, err := execute(t, checkManifests, "check", "-f", "-", "--funcs", "rename"); err == nil {
+		t.Error("mutating admission func is run by check")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"go.uber.org/zap"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Config file tools",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Parse and validate the config file, environment variables and flags",
+	Example: `  goadmission config validate --config /etc/goadmission/config.yaml
+  GOADMISSION_ALLOW_DEPLOY_TIME=05:00~10:00 goadmission config validate`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := configFlags.WatchConfigMap(cmd.Context(), kubeClient, nil); err != nil {
+			return err
+		}
+		cfg, err := configFlags.Load()
+		if err != nil {
+			return err
+		}
+		dispatcher := adfunc.NewDispatcher(adfunc.DefaultRegistry, cfg, zap.NewNop().Sugar())
+		if err = dispatcher.Check(); err != nil {
+			return fmt.Errorf("config is invalid:\n%w", err)
+		}
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), "config is valid")
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
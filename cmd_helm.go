@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/manifests"
+)
+
+var helmFlags offlineFlags
+var helmPodTemplates bool
+
+var helmPostRenderCmd = &cobra.Command{
+	Use:   "helm-post-render",
+	Short: "Run the mutating admission funcs as a Helm post-renderer",
+	Long: `Run the mutating admission funcs as a Helm post-renderer: the manifests rendered by Helm are read
+from stdin, mutated as the mutate subcommand does and written to stdout, so that the charts installed into
+clusters without the webhook are patched as well, e.g. the images renamed by /mutating/rename.
+The documents not changed by the admission funcs are written as they are, and lists are kept as lists.
+The denials are written to stderr and it exits non-zero on any denial, which fails the Helm release.`,
+	Example: `  helm install nginx bitnami/nginx --post-renderer goadmission \
+    --post-renderer-args helm-post-render --post-renderer-args --funcs=rename`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := helmFlags
+		op, err := f.op()
+		if err != nil {
+			return err
+		}
+		d, err := offlineDispatcher(cmd)
+		if err != nil {
+			return err
+		}
+		paths, err := d.FuncPaths(adfunc.AdmissionTypeMutating, f.funcs)
+		if err != nil {
+			return err
+		}
+		docs, err := manifests.ReadDocuments(cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		var objs []*unstructured.Unstructured
+		for _, doc := range docs {
+			objs = append(objs, doc.Objects()...)
+		}
+		mutations, err := mutateObjects(cmd.Context(), d, paths, objs, op, f.userInfo(), helmPodTemplates)
+		if err != nil {
+			return err
+		}
+
+		var denied int
+		for _, m := range mutations {
+			for _, msg := range m.denials {
+				denied++
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "deny\t%s: %s\n", objectName(m.obj), msg)
+			}
+			for _, w := range m.warnings {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "warn\t%s: %s\n", objectName(m.obj), w)
+			}
+		}
+		if denied > 0 {
+			return fmt.Errorf("%d denial(s) found", denied)
+		}
+
+		out := make([][]byte, 0, len(docs))
+		for _, doc := range docs {
+			n := len(doc.Objects())
+			bs, err := renderDocument(doc, mutations[:n])
+			if err != nil {
+				return err
+			}
+			mutations = mutations[n:]
+			out = append(out, bs)
+		}
+		return writeDocuments(cmd.OutOrStdout(), out)
+	},
+}
+
+// renderDocument returns the YAML of the document with the mutations of its objects, the
+// document is kept as it is if no object is changed, and lists are kept as lists.
+func renderDocument(doc manifests.Document, mutations []mutation) ([]byte, error) {
+	var changed bool
+	for _, m := range mutations {
+		if !jsonpatch.Equal(m.original, m.patched) {
+			changed = true
+		}
+	}
+	if !changed {
+		return doc.Raw, nil
+	}
+	if doc.List == nil {
+		return yaml.JSONToYAML(mutations[0].patched)
+	}
+	list := doc.List.DeepCopy()
+	for i, m := range mutations {
+		if err := list.Items[i].UnmarshalJSON(m.patched); err != nil {
+			return nil, err
+		}
+	}
+	bs, err := list.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return yaml.JSONToYAML(bs)
+}
+
+// writeDocuments writes the YAML documents as a multi-document YAML
+func writeDocuments(w io.Writer, docs [][]byte) error {
+	for i, doc := range docs {
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(doc); err != nil {
+			return err
+		}
+		if len(doc) > 0 && doc[len(doc)-1] != '\n' {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	helmFlags.registerRequest(helmPostRenderCmd, "Mutating admission funcs to run in order, e.g. rename,disable-service-links, all mutating funcs by default")
+	helmPostRenderCmd.Flags().BoolVar(&helmPodTemplates, "pod-templates", true, "Mutate the pod templates of workloads as the pods created from them")
+	rootCmd.AddCommand(helmPostRenderCmd)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelmPostRenderDeterministic(t *testing.T) {
+	out := executeTwice(t, offlineManifests, "helm-post-render", "--funcs", "rename,disable-service-links")
+	checkMutated(t, out)
+}
+
+func TestHelmPostRenderPassThrough(t *testing.T) {
+	stdin := `# Source: chart/templates/NOTES.txt
+---
+# Source: chart/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx # not selected
+data:
+  b: "2"
+  a: "1"
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: nginx
+  spec:
+    containers:
+    - name: nginx
+      image: k8s.gcr.io/nginx:1.21
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: nginx
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: nginx-headless
+`
+	stdout, stderr, err := execute(t, stdin, "helm-post-render", "--funcs", "rename")
+	if err != nil {
+		t.Fatalf("helm-post-render: %v: %s", err, stderr)
+	}
+	docs := strings.Split(stdout, "---\n")
+	if len(docs) != 4 {
+		t.Fatalf("got %d documents, want 4: %s", len(docs), stdout)
+	}
+	in := strings.Split(stdin, "---\n")
+	for _, i := range []int{0, 1, 3} {
+		if docs[i] != in[i] {
+			t.Errorf("document %d is changed:\n%s\nwant:\n%s", i, docs[i], in[i])
+		}
+	}
+	if !strings.Contains(docs[2], "kind: List") || !strings.Contains(docs[2], "image: gcrxio/k8s.gcr.io_nginx:1.21") {
+		t.Errorf("list is not kept with the renamed image:\n%s", docs[2])
+	}
+}
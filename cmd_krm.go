@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+)
+
+const (
+	resourceListAPIVersion = "config.kubernetes.io/v1"
+	resourceListKind       = "ResourceList"
+)
+
+// resourceList is the input and output of KRM functions,
+// see https://github.com/kubernetes-sigs/kustomize/blob/master/cmd/config/docs/api-conventions/functions-spec.md
+type resourceList struct {
+	APIVersion     string                     `json:"apiVersion"`
+	Kind           string                     `json:"kind"`
+	Items          []json.RawMessage          `json:"items"`
+	FunctionConfig *unstructured.Unstructured `json:"functionConfig,omitempty"`
+	Results        []krmResult                `json:"results,omitempty"`
+}
+
+type krmResult struct {
+	Message     string          `json:"message"`
+	Severity    string          `json:"severity"`
+	ResourceRef *krmResourceRef `json:"resourceRef,omitempty"`
+}
+
+type krmResourceRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+var krmFlags offlineFlags
+var krmPodTemplates bool
+
+var krmCmd = &cobra.Command{
+	Use:   "krm",
+	Short: "Run the admission funcs as a KRM function of kustomize and kpt",
+	Long: `Run the admission funcs as a KRM function: a config.kubernetes.io/v1 ResourceList is read from stdin,
+the items are mutated by the mutating admission funcs and then reviewed by the validating admission
+funcs, the mutated items and the denials in results are written to stdout. The flags can be set by
+the data of a ConfigMap functionConfig as well, e.g. "funcs: rename,check-deploy-time".
+It exits non-zero on any denial.`,
+	Example: `  goadmission krm --funcs rename,check-deploy-time < resource-list.yaml
+  kpt fn source ./k8s | goadmission krm --funcs rename | kpt fn sink ./out`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rl, err := readResourceList(cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		f := krmFlags
+		if err = f.setFunctionConfig(rl.FunctionConfig); err != nil {
+			return err
+		}
+		op, err := f.op()
+		if err != nil {
+			return err
+		}
+		d, err := offlineDispatcher(cmd)
+		if err != nil {
+			return err
+		}
+		mutating, validating, err := krmFuncPaths(d, f.funcs)
+		if err != nil {
+			return err
+		}
+
+		objs := make([]*unstructured.Unstructured, 0, len(rl.Items))
+		for i, item := range rl.Items {
+			obj := &unstructured.Unstructured{}
+			if err = obj.UnmarshalJSON(item); err != nil {
+				return fmt.Errorf("items[%d]: %w", i, err)
+			}
+			objs = append(objs, obj)
+		}
+		mutations, err := mutateObjects(cmd.Context(), d, mutating, objs, op, f.userInfo(), krmPodTemplates)
+		if err != nil {
+			return err
+		}
+
+		var denied int
+		addResult := func(obj *unstructured.Unstructured, severity, message string) {
+			if severity == "error" {
+				denied++
+			}
+			rl.Results = append(rl.Results, krmResult{
+				Message:  message,
+				Severity: severity,
+				ResourceRef: &krmResourceRef{
+					APIVersion: obj.GetAPIVersion(),
+					Kind:       obj.GetKind(),
+					Name:       obj.GetName(),
+					Namespace:  obj.GetNamespace(),
+				},
+			})
+		}
+		for i, m := range mutations {
+			rl.Items[i] = m.patched
+			for _, msg := range m.denials {
+				addResult(m.obj, "error", msg)
+			}
+			for _, w := range m.warnings {
+				addResult(m.obj, "warning", w)
+			}
+			if len(m.denials) > 0 || len(validating) == 0 {
+				continue
+			}
+
+			obj := &unstructured.Unstructured{}
+			if err = obj.UnmarshalJSON(m.patched); err != nil {
+				return err
+			}
+			request, err := adfunc.NewRequest(obj, op, f.userInfo())
+			if err != nil {
+				return err
+			}
+			for _, r := range d.Review(cmd.Context(), validating, request) {
+				switch {
+				case r.Err != nil:
+					addResult(obj, "error", fmt.Sprintf("%s: %v", r.Path, r.Err))
+				case r.InScope && !r.Response.Allowed:
+					addResult(obj, "error", fmt.Sprintf("%s: %s", r.Path, message(r.Response)))
+				}
+				if r.Response != nil {
+					for _, w := range r.Response.Warnings {
+						addResult(obj, "warning", fmt.Sprintf("%s: %s", r.Path, w))
+					}
+				}
+			}
+		}
+
+		bs, err := yaml.Marshal(rl)
+		if err != nil {
+			return err
+		}
+		if _, err = cmd.OutOrStdout().Write(bs); err != nil {
+			return err
+		}
+		if denied > 0 {
+			return fmt.Errorf("%d denial(s) found", denied)
+		}
+		return nil
+	},
+}
+
+// readResourceList reads a YAML or JSON ResourceList
+func readResourceList(r io.Reader) (*resourceList, error) {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var rl resourceList
+	if err = yaml.Unmarshal(bs, &rl); err != nil {
+		return nil, fmt.Errorf("failed to decode ResourceList: %w", err)
+	}
+	if rl.Kind != resourceListKind || (rl.APIVersion != resourceListAPIVersion && rl.APIVersion != "config.kubernetes.io/v1alpha1") {
+		return nil, fmt.Errorf("unsupported input %s %s, %s %s is required", rl.APIVersion, rl.Kind, resourceListAPIVersion, resourceListKind)
+	}
+	rl.APIVersion = resourceListAPIVersion
+	rl.Results = nil
+	return &rl, nil
+}
+
+// setFunctionConfig sets the flags by the data of the ConfigMap functionConfig, other kinds are ignored
+func (f *offlineFlags) setFunctionConfig(fc *unstructured.Unstructured) error {
+	if fc == nil || fc.GetKind() != "ConfigMap" {
+		return nil
+	}
+	data, _, err := unstructured.NestedStringMap(fc.Object, "data")
+	if err != nil {
+		return fmt.Errorf("functionConfig: %w", err)
+	}
+	split := func(s string) []string {
+		var values []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	if v, ok := data["funcs"]; ok {
+		f.funcs = split(v)
+	}
+	if v, ok := data["operation"]; ok {
+		f.operation = v
+	}
+	if v, ok := data["user"]; ok {
+		f.user = v
+	}
+	if v, ok := data["groups"]; ok {
+		f.groups = split(v)
+	}
+	return nil
+}
+
+// krmFuncPaths resolves the handle paths of the mutating and validating admission funcs by name,
+// a name is resolved as both types if both are registered, e.g. "print". All admission funcs are
+// returned if names is empty.
+func krmFuncPaths(d *adfunc.Dispatcher, names []string) (mutating, validating []string, err error) {
+	if len(names) == 0 {
+		if mutating, err = d.FuncPaths(adfunc.AdmissionTypeMutating, nil); err != nil {
+			return nil, nil, err
+		}
+		validating, err = d.FuncPaths(adfunc.AdmissionTypeValidating, nil)
+		return mutating, validating, err
+	}
+
+	var errs []error
+	for _, name := range names {
+		m, mErr := d.FuncPaths(adfunc.AdmissionTypeMutating, []string{name})
+		v, vErr := d.FuncPaths(adfunc.AdmissionTypeValidating, []string{name})
+		if mErr != nil && vErr != nil {
+			errs = append(errs, fmt.Errorf("admission func [%s] is not registered", name))
+			continue
+		}
+		if mErr == nil {
+			mutating = append(mutating, m...)
+		}
+		if vErr == nil {
+			validating = append(validating, v...)
+		}
+	}
+	return mutating, validating, errors.Join(errs...)
+}
+
+func init() {
+	krmFlags.registerRequest(krmCmd, "Admission funcs to run, e.g. rename,check-deploy-time, the mutating funcs run in order before the validating funcs, all admission funcs by default")
+	krmCmd.Flags().BoolVar(&krmPodTemplates, "pod-templates", true, "Mutate the pod templates of workloads as the pods created from them")
+	rootCmd.AddCommand(krmCmd)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKRMDeterministic(t *testing.T) {
+	var items strings.Builder
+	for _, doc := range strings.Split(offlineManifests, "---\n") {
+		items.WriteString("- " + strings.ReplaceAll(strings.TrimSpace(doc), "\n", "\n  ") + "\n")
+	}
+	resourceList := "apiVersion: config.kubernetes.io/v1\nkind: ResourceList\nitems:\n" + items.String() +
+		"functionConfig:\n  apiVersion: v1\n  kind: ConfigMap\n  metadata:\n    name: goadmission\n  data:\n    funcs: rename,disable-service-links\n"
+
+	out := executeTwice(t, resourceList, "krm")
+	checkMutated(t, out)
+	if !strings.Contains(out, "kind: ResourceList") {
+		t.Errorf("output is not a ResourceList:\n%s", out)
+	}
+}
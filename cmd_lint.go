@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/manifests"
+)
+
+var lintFiles []string
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check webhook configurations against the registered admission funcs",
+	Long: `Check the webhooks of Mutating/ValidatingWebhookConfiguration objects against the routes of
+the registered admission funcs and the configured pipelines: the service path, the webhook type,
+the kinds and operations of the rules, sideEffects, timeoutSeconds and the namespaceSelector
+exclusion of the webhook namespace.
+It exits non-zero if any error is found.`,
+	Example: `  goadmission lint -f deploy/mutatingwebhook/mutatingwebhook.yaml
+  kubectl get mutatingwebhookconfigurations -o yaml | goadmission lint -f -`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(lintFiles) == 0 {
+			return fmt.Errorf("-f is required")
+		}
+		cfg, err := configFlags.Load()
+		if err != nil {
+			return err
+		}
+		objs, err := manifests.ReadFiles(lintFiles, cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		issues, err := manifests.Lint(adfunc.DefaultRegistry, cfg, objs)
+		if err != nil {
+			return err
+		}
+
+		var errs int
+		for _, issue := range issues {
+			if issue.Severity == manifests.SeverityError {
+				errs++
+			}
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), issue)
+		}
+		if errs > 0 {
+			return fmt.Errorf("%d error(s) found", errs)
+		}
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), "no errors found")
+		return nil
+	},
+}
+
+func init() {
+	lintCmd.Flags().StringArrayVarP(&lintFiles, "filename", "f", nil, "Manifest files or directories, '-' is stdin")
+	rootCmd.AddCommand(lintCmd)
+}
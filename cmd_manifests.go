@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/manifests"
+)
+
+var manifestsOpts = manifests.Options{}
+var manifestsFlags = struct {
+	failurePolicy string
+	caBundle      string
+	caFile        string
+	output        string
+}{}
+
+var manifestsCmd = &cobra.Command{
+	Use:   "manifests",
+	Short: "Generate the webhook configurations, Service and Deployment of the registered admission funcs",
+	Long: `Generate the webhook configurations, Service and Deployment of the registered admission funcs,
+every admission func has a webhook of its handle path, rules, side effects and timeout. The admission
+funcs that declare no Kinds, e.g. print, have catch-all rules matching every resource, so they are
+skipped unless they are named by --funcs.`,
+	Example: `  goadmission manifests --ca-bundle "$(goadmission certs generate --service goadmission --namespace kube-addons)"
+  goadmission manifests --manage-certs --service-account goadmission -o deploy/goadmission.yaml
+  goadmission manifests --funcs rename,check-deploy-time,print`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := manifestsFlags
+		opts := manifestsOpts
+		opts.FailurePolicy = admissionregistrationv1.FailurePolicyType(f.failurePolicy)
+		if opts.FailurePolicy != admissionregistrationv1.Ignore && opts.FailurePolicy != admissionregistrationv1.Fail {
+			return fmt.Errorf("unsupported failure policy: %s", f.failurePolicy)
+		}
+		switch {
+		case f.caFile != "":
+			bs, err := os.ReadFile(f.caFile)
+			if err != nil {
+				return err
+			}
+			opts.CABundle = bs
+		case f.caBundle != "":
+			bs, err := base64.StdEncoding.DecodeString(f.caBundle)
+			if err != nil {
+				return fmt.Errorf("invalid --ca-bundle: %w", err)
+			}
+			opts.CABundle = bs
+		}
+
+		objs, err := manifests.Generate(adfunc.DefaultRegistry, opts)
+		if err != nil {
+			return err
+		}
+		selected, skipped, _ := manifests.Select(adfunc.DefaultRegistry, opts.Funcs)
+		funcs := adfunc.DefaultRegistry.Funcs()
+		for _, p := range selected {
+			if len(funcs[p].Kinds) == 0 {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "warn %s: it declares no Kinds, the webhook matches all resources\n", p)
+			}
+		}
+		for _, p := range skipped {
+			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "skip %s: it declares no Kinds, name it by --funcs to generate the catch-all webhook\n", p)
+		}
+		if f.output == "" || f.output == "-" {
+			return manifests.Write(cmd.OutOrStdout(), objs)
+		}
+		out, err := os.Create(f.output)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = out.Close() }()
+		return manifests.Write(out, objs)
+	},
+}
+
+func init() {
+	manifestsCmd.Flags().StringVar(&manifestsOpts.Name, "name", manifests.DefaultName, "Name of the webhook configurations, Service and Deployment")
+	manifestsCmd.Flags().StringVarP(&manifestsOpts.Namespace, "namespace", "n", manifests.DefaultNamespace, "Namespace of the Service and Deployment")
+	manifestsCmd.Flags().StringVar(&manifestsOpts.Image, "image", manifests.DefaultImage, "Image of the Deployment")
+	manifestsCmd.Flags().Int32Var(&manifestsOpts.Replicas, "replicas", 1, "Replicas of the Deployment")
+	manifestsCmd.Flags().StringVar(&manifestsOpts.Domain, "domain", manifests.DefaultDomain, "Domain suffix of the webhook names")
+	manifestsCmd.Flags().StringVar(&manifestsOpts.ServiceAccount, "service-account", "", "ServiceAccount of the Deployment")
+	manifestsCmd.Flags().BoolVar(&manifestsOpts.ManageCerts, "manage-certs", false, "Run the Deployment with --manage-certs, the caBundle is injected at runtime")
+	manifestsCmd.Flags().StringVar(&manifestsOpts.TLSSecret, "tls-secret", manifests.DefaultTLSSecret, "Secret of the TLS files (dac.pem and dac-key.pem) mounted by the Deployment")
+	manifestsCmd.Flags().StringSliceVar(&manifestsOpts.Funcs, "funcs", nil, "Admission funcs to generate webhooks for, e.g. rename,print, all funcs declaring Kinds by default")
+	manifestsCmd.Flags().StringVar(&manifestsFlags.failurePolicy, "failure-policy", string(admissionregistrationv1.Ignore), "Failure policy of the webhooks ('Ignore' or 'Fail')")
+	manifestsCmd.Flags().StringVar(&manifestsFlags.caBundle, "ca-bundle", "", "Base64 encoded CA bundle of the webhooks, e.g. the output of 'goadmission certs generate'")
+	manifestsCmd.Flags().StringVar(&manifestsFlags.caFile, "ca-file", "", "CA file of the webhooks, it overrides --ca-bundle")
+	manifestsCmd.Flags().StringVarP(&manifestsFlags.output, "output", "o", "", "Output file, '-' or empty means stdout")
+
+	rootCmd.AddCommand(manifestsCmd)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/manifests"
+)
+
+var mutateFlags offlineFlags
+var mutateDiff, mutatePodTemplates bool
+
+var mutateCmd = &cobra.Command{
+	Use:   "mutate",
+	Short: "Run the mutating admission funcs on manifests and print the patched manifests",
+	Long: `Run the mutating admission funcs on manifests in order, every object is wrapped in a synthetic
+admission request, the JSON patches returned by the funcs are applied and the patched manifests
+are written to stdout. The pod templates of workloads are mutated as the pods created from them,
+e.g. the images renamed by /mutating/rename. With --diff the changes are written as a unified diff instead.
+It exits non-zero on any denial.`,
+	Example: `  goadmission mutate -f deploy.yaml --funcs rename,disable-service-links
+  goadmission mutate -f deploy.yaml --funcs rename --diff`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := mutateFlags
+		if len(f.files) == 0 {
+			return fmt.Errorf("-f is required")
+		}
+		op, err := f.op()
+		if err != nil {
+			return err
+		}
+		d, err := offlineDispatcher(cmd)
+		if err != nil {
+			return err
+		}
+		paths, err := d.FuncPaths(adfunc.AdmissionTypeMutating, f.funcs)
+		if err != nil {
+			return err
+		}
+		objs, err := manifests.ReadFiles(f.files, cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		mutations, err := mutateObjects(cmd.Context(), d, paths, objs, op, f.userInfo(), mutatePodTemplates)
+		if err != nil {
+			return err
+		}
+
+		var denied int
+		docs := make([][]byte, 0, len(mutations))
+		for _, m := range mutations {
+			for _, msg := range m.denials {
+				denied++
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "deny\t%s: %s\n", objectName(m.obj), msg)
+			}
+			for _, w := range m.warnings {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "warn\t%s: %s\n", objectName(m.obj), w)
+			}
+			if !mutateDiff {
+				docs = append(docs, m.patched)
+				continue
+			}
+			diff, err := yamlDiff(objectName(m.obj), m.original, m.patched)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprint(cmd.OutOrStdout(), diff)
+		}
+		if !mutateDiff {
+			if err = writeYAML(cmd.OutOrStdout(), docs); err != nil {
+				return err
+			}
+		}
+		if denied > 0 {
+			return fmt.Errorf("%d denial(s) found", denied)
+		}
+		return nil
+	},
+}
+
+// yamlDiff returns the unified diff of the YAML of the JSON documents, it is empty if they are equal
+func yamlDiff(name string, original, patched []byte) (string, error) {
+	a, err := yaml.JSONToYAML(original)
+	if err != nil {
+		return "", err
+	}
+	b, err := yaml.JSONToYAML(patched)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: "a/" + name,
+		ToFile:   "b/" + name,
+		Context:  3,
+	})
+}
+
+func init() {
+	mutateFlags.register(mutateCmd, "Mutating admission funcs to run in order, e.g. rename,disable-service-links, all mutating funcs by default")
+	mutateCmd.Flags().BoolVar(&mutateDiff, "diff", false, "Print the unified diff of the changes instead of the patched manifests")
+	mutateCmd.Flags().BoolVar(&mutatePodTemplates, "pod-templates", true, "Mutate the pod templates of workloads as the pods created from them")
+	rootCmd.AddCommand(mutateCmd)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMutateDiff(t *testing.T) {
+	stdin := offlineManifests + `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+`
+	stdout, stderr, err := execute(t, stdin, "mutate", "-f", "-", "--funcs", "rename", "--diff")
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	// the ConfigMap is not changed, so it has no diff
+	want := `--- a/Deployment default/nginx
++++ b/Deployment default/nginx
+@@ -9,10 +9,12 @@
+       app: nginx
+   template:
+     metadata:
++      annotations:
++        rename-mutatingwebhook.mritd.com: 0-k8s.gcr.io_-gcrxio_k8s.gcr.io_
+       labels:
+         app: nginx
+     spec:
+       containers:
+-      - image: k8s.gcr.io/nginx:1.21
++      - image: gcrxio/k8s.gcr.io_nginx:1.21
+         name: nginx
+ 
+--- a/Pod default/pause
++++ b/Pod default/pause
+@@ -1,10 +1,12 @@
+ apiVersion: v1
+ kind: Pod
+ metadata:
++  annotations:
++    rename-mutatingwebhook.mritd.com: 0-k8s.gcr.io_-gcrxio_k8s.gcr.io_
+   name: pause
+   namespace: default
+ spec:
+   containers:
+-  - image: k8s.gcr.io/pause:3.6
++  - image: gcrxio/k8s.gcr.io_pause:3.6
+     name: pause
+ 
+`
+	if stdout != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestMutate(t *testing.T) {
+	out := executeTwice(t, offlineManifests, "mutate", "-f", "-")
+	checkMutated(t, out)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+)
+
+// offlineFlags are the flags of the subcommands that run admission funcs on manifests
+type offlineFlags struct {
+	files     []string
+	funcs     []string
+	operation string
+	user      string
+	groups    []string
+}
+
+func (f *offlineFlags) register(cmd *cobra.Command, funcsUsage string) {
+	cmd.Flags().StringArrayVarP(&f.files, "filename", "f", nil, "Manifest files or directories, '-' is stdin")
+	f.registerRequest(cmd, funcsUsage)
+}
+
+// registerRequest registers the flags of the admission funcs and the synthetic admission requests
+func (f *offlineFlags) registerRequest(cmd *cobra.Command, funcsUsage string) {
+	cmd.Flags().StringSliceVar(&f.funcs, "funcs", nil, funcsUsage)
+	cmd.Flags().StringVar(&f.operation, "operation", string(admissionv1.Create), "Operation of the synthetic admission requests ('CREATE', 'UPDATE' or 'DELETE')")
+	cmd.Flags().StringVar(&f.user, "user", "", "User of the synthetic admission requests")
+	cmd.Flags().StringSliceVar(&f.groups, "group", nil, "User groups of the synthetic admission requests")
+}
+
+func (f *offlineFlags) userInfo() authenticationv1.UserInfo {
+	return authenticationv1.UserInfo{Username: f.user, Groups: f.groups}
+}
+
+func (f *offlineFlags) op() (admissionv1.Operation, error) {
+	switch op := admissionv1.Operation(strings.ToUpper(f.operation)); op {
+	case admissionv1.Create, admissionv1.Update, admissionv1.Delete:
+		return op, nil
+	default:
+		return "", fmt.Errorf("unsupported operation: %s", f.operation)
+	}
+}
+
+// offlineDispatcher returns the dispatcher of the config, the logs are discarded
+// since the stdout of the offline subcommands is their result.
+func offlineDispatcher(cmd *cobra.Command) (*adfunc.Dispatcher, error) {
+	cfg, err := configFlags.Load()
+	if err != nil {
+		return nil, err
+	}
+	d := adfunc.NewDispatcher(adfunc.DefaultRegistry, cfg, zap.NewNop().Sugar())
+	if err = d.Check(); err != nil {
+		return nil, fmt.Errorf("config is invalid:\n%w", err)
+	}
+	return d, nil
+}
+
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetKind() + " " + obj.GetName()
+	}
+	return obj.GetKind() + " " + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// message returns the result message of the response
+func message(resp *admissionv1.AdmissionResponse) string {
+	if resp.Result == nil {
+		return ""
+	}
+	return resp.Result.Message
+}
+
+// mutation is the result of the mutating admission funcs on an object
+type mutation struct {
+	obj      *unstructured.Unstructured
+	original []byte
+	patched  []byte
+	denials  []string
+	warnings []string
+}
+
+// podTemplateFields returns the field path of the pod template of the workload, or nil
+func podTemplateFields(obj *unstructured.Unstructured) []string {
+	switch obj.GetKind() {
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		return []string{"spec", "template"}
+	case "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template"}
+	}
+	return nil
+}
+
+// mutateObjects runs the mutating admission funcs of the paths on the objects in order, the
+// objects are kept as they are if they are denied. If podTemplates is set, the pod templates of
+// workloads are mutated as the pods created from them, e.g. the images renamed by /mutating/rename.
+func mutateObjects(ctx context.Context, d *adfunc.Dispatcher, paths []string, objs []*unstructured.Unstructured, op admissionv1.Operation, userInfo authenticationv1.UserInfo, podTemplates bool) ([]mutation, error) {
+	if op == admissionv1.Delete {
+		return nil, fmt.Errorf("operation %s can not be mutated", op)
+	}
+	mutations := make([]mutation, 0, len(objs))
+	for _, obj := range objs {
+		m := mutation{obj: obj}
+		var err error
+		if m.original, err = obj.MarshalJSON(); err != nil {
+			return nil, err
+		}
+		if m.patched, err = m.mutate(ctx, d, paths, obj, op, userInfo); err != nil {
+			return nil, err
+		}
+		if fields := podTemplateFields(obj); podTemplates && fields != nil && len(m.denials) == 0 {
+			if err = m.mutatePodTemplate(ctx, d, paths, fields, op, userInfo); err != nil {
+				return nil, err
+			}
+		}
+		mutations = append(mutations, m)
+	}
+	return mutations, nil
+}
+
+// mutate runs the mutating admission funcs on the object and records the denials and
+// warnings, it returns the patched object.
+func (m *mutation) mutate(ctx context.Context, d *adfunc.Dispatcher, paths []string, obj *unstructured.Unstructured, op admissionv1.Operation, userInfo authenticationv1.UserInfo) ([]byte, error) {
+	request, err := adfunc.NewRequest(obj, op, userInfo)
+	if err != nil {
+		return nil, err
+	}
+	resp, patched, err := d.Mutate(ctx, paths, request)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", objectName(obj), err)
+	}
+	if !resp.Allowed {
+		m.denials = append(m.denials, message(resp))
+	}
+	m.warnings = append(m.warnings, resp.Warnings...)
+	return patched, nil
+}
+
+// mutatePodTemplate runs the mutating admission funcs on a pod of the pod template, and writes
+// the patched metadata and spec of the pod back to the pod template of the workload.
+func (m *mutation) mutatePodTemplate(ctx context.Context, d *adfunc.Dispatcher, paths []string, fields []string, op admissionv1.Operation, userInfo authenticationv1.UserInfo) error {
+	workload := &unstructured.Unstructured{}
+	if err := workload.UnmarshalJSON(m.patched); err != nil {
+		return err
+	}
+	template, found, err := unstructured.NestedMap(workload.Object, fields...)
+	if err != nil || !found {
+		return err
+	}
+	pod := &unstructured.Unstructured{Object: template}
+	pod.SetAPIVersion("v1")
+	pod.SetKind("Pod")
+	pod.SetNamespace(workload.GetNamespace())
+	pod.SetName(workload.GetName())
+
+	patched, err := m.mutate(ctx, d, paths, pod, op, userInfo)
+	if err != nil || len(m.denials) > 0 {
+		return err
+	}
+	patchedPod := &unstructured.Unstructured{}
+	if err = patchedPod.UnmarshalJSON(patched); err != nil {
+		return err
+	}
+	metadata, _, _ := unstructured.NestedMap(patchedPod.Object, "metadata")
+	delete(metadata, "name")
+	delete(metadata, "namespace")
+	patchedTemplate := map[string]interface{}{"spec": patchedPod.Object["spec"]}
+	if len(metadata) > 0 {
+		patchedTemplate["metadata"] = metadata
+	}
+	if err = unstructured.SetNestedMap(workload.Object, patchedTemplate, fields...); err != nil {
+		return err
+	}
+	m.patched, err = workload.MarshalJSON()
+	return err
+}
+
+// writeYAML writes the JSON documents as a multi-document YAML
+func writeYAML(w io.Writer, docs [][]byte) error {
+	for i, doc := range docs {
+		bs, err := yaml.JSONToYAML(doc)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err = io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err = w.Write(bs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// execute runs the root command with the args and stdin, and returns the stdout and stderr.
+// The flags set by args are reset when the test ends, since the commands are shared by tests.
+func execute(t *testing.T, stdin string, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	t.Cleanup(func() { resetFlags(rootCmd) })
+	var out, errOut bytes.Buffer
+	rootCmd.SetArgs(args)
+	rootCmd.SetIn(strings.NewReader(stdin))
+	rootCmd.SetOut(&out)
+	rootCmd.SetErr(&errOut)
+	err = rootCmd.Execute()
+	return out.String(), errOut.String(), err
+}
+
+// resetFlags sets the changed flags of the command and its subcommands to their defaults
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			var values []string
+			if def := strings.Trim(f.DefValue, "[]"); def != "" {
+				values = strings.Split(def, ",")
+			}
+			_ = sv.Replace(values)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, c := range cmd.Commands() {
+		resetFlags(c)
+	}
+}
+
+// offlineManifests is the manifests mutated by /mutating/rename and /mutating/disable-service-links
+const offlineManifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      app: nginx
+  template:
+    metadata:
+      labels:
+        app: nginx
+    spec:
+      containers:
+      - name: nginx
+        image: k8s.gcr.io/nginx:1.21
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: pause
+  namespace: default
+spec:
+  containers:
+  - name: pause
+    image: k8s.gcr.io/pause:3.6
+`
+
+// executeTwice runs the command twice with the same input and checks that the outputs are equal
+func executeTwice(t *testing.T, stdin string, args ...string) string {
+	t.Helper()
+	first, stderr, err := execute(t, stdin, args...)
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	resetFlags(rootCmd)
+	second, stderr, err := execute(t, stdin, args...)
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	if first != second {
+		t.Errorf("output is not deterministic:\n--- first\n%s\n--- second\n%s", first, second)
+	}
+	return first
+}
+
+// checkMutated checks the annotations and images mutated by /mutating/rename and /mutating/disable-service-links
+func checkMutated(t *testing.T, out string) {
+	t.Helper()
+	for _, s := range []string{
+		"disable-service-links-mutatingwebhook.mritd.com: \"true\"",
+		"rename-mutatingwebhook.mritd.com: 0-k8s.gcr.io_-gcrxio_k8s.gcr.io_",
+		"enableServiceLinks: false",
+		"image: gcrxio/k8s.gcr.io_nginx:1.21",
+		"image: gcrxio/k8s.gcr.io_pause:3.6",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output has no %q:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/route"
+)
+
+var replayPath string
+var replayLogs bool
+
+var replayCmd = &cobra.Command{
+	Use:   "replay [--path <handle path>] review.json [review.json...]",
+	Short: "Replay recorded AdmissionReviews through the webhook handler",
+	Long: `Replay recorded AdmissionReviews through the http handler of the webhook in-process, the request
+is served exactly as the webhook does without network. The response, the decoded JSON patch and
+the object after the patch is applied are printed for every file, '-' is stdin. The files are
+AdmissionReviews in JSON or YAML, or the JSONL record files of --record-dir, --path is required
+for AdmissionReviews and overrides the paths of records.`,
+	Example: `  goadmission replay --path /mutating/rename pkg/adfunc/testdata/review-v1.json
+  goadmission replay --path /validating/check-deploy-time --allow-deploy-time 00:00~23:59 review.json
+  goadmission replay /var/lib/goadmission/records/goadmission-7d4b9c-x2k8f-20221018.jsonl`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := configFlags.Load()
+		if err != nil {
+			return err
+		}
+		logger := zap.NewNop()
+		if replayLogs {
+			logger = zap.New(zapcore.NewCore(
+				zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()),
+				zapcore.Lock(os.Stderr),
+				zap.DebugLevel,
+			))
+		}
+		// the handler is served in-process, so the TLS files of the config are not loaded
+		router := route.NewRouter(logger.Named("route").Sugar())
+		dispatcher := adfunc.NewDispatcher(adfunc.DefaultRegistry, cfg, logger.Named("adfunc").Sugar())
+		defer dispatcher.Close()
+		if err = dispatcher.Setup(router); err != nil {
+			return err
+		}
+		handler := router.Handler()
+
+		out := cmd.OutOrStdout()
+		for i, file := range args {
+			var body []byte
+			if file == "-" {
+				body, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				body, err = os.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				_, _ = fmt.Fprintln(out)
+			}
+
+			if records, err := adfunc.ReadRecords(bytes.NewReader(body)); err == nil && len(records) > 0 && records[0].Path != "" {
+				for n, record := range records {
+					path := record.Path
+					if replayPath != "" {
+						path = replayPath
+					}
+					if n > 0 {
+						_, _ = fmt.Fprintln(out)
+					}
+					_, _ = fmt.Fprintf(out, "==> %s:%d: %s\n", file, n+1, path)
+					if err = replay(out, handler, path, record.Request); err != nil {
+						return fmt.Errorf("%s:%d: %w", file, n+1, err)
+					}
+				}
+				continue
+			}
+
+			if replayPath == "" {
+				return fmt.Errorf("%s: --path is required to replay AdmissionReviews", file)
+			}
+			_, _ = fmt.Fprintf(out, "==> %s: %s\n", file, replayPath)
+			if err = replay(out, handler, replayPath, body); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+		return nil
+	},
+}
+
+// replay posts the AdmissionReview to the handle path of the handler, and prints the response,
+// the decoded patch and the patched object
+func replay(w io.Writer, handler http.Handler, path string, body []byte) error {
+	body, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		return err
+	}
+	var reqReview admissionv1.AdmissionReview
+	if err = json.Unmarshal(body, &reqReview); err != nil {
+		return fmt.Errorf("failed to decode AdmissionReview: %w", err)
+	}
+	if reqReview.Request == nil {
+		return fmt.Errorf("AdmissionReview has no request")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("http %d: %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+
+	// the v1beta1 response has the same fields as v1
+	var respReview admissionv1.AdmissionReview
+	if err = json.Unmarshal(rec.Body.Bytes(), &respReview); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	resp := respReview.Response
+	if resp == nil {
+		return fmt.Errorf("AdmissionReview has no response")
+	}
+	patch := resp.Patch
+	resp.Patch = nil
+
+	bs, err := yaml.Marshal(respReview)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "--- response\n%s", bs)
+	if len(patch) == 0 {
+		return nil
+	}
+
+	ops, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return fmt.Errorf("failed to decode patch: %w", err)
+	}
+	_, _ = fmt.Fprintln(w, "--- patch")
+	for _, op := range ops {
+		bs, err = json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "%s\n", bs)
+	}
+
+	patched, err := ops.Apply(reqReview.Request.Object.Raw)
+	if err != nil {
+		return fmt.Errorf("failed to apply patch: %w", err)
+	}
+	if bs, err = yaml.JSONToYAML(patched); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "--- object\n%s", bs)
+	return nil
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayPath, "path", "", "Handle path of the admission func or pipeline, e.g. /mutating/rename, it overrides the paths of records")
+	replayCmd.Flags().BoolVar(&replayLogs, "logs", false, "Write the logs of the webhook handler to stderr")
+	rootCmd.AddCommand(replayCmd)
+}
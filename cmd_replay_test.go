@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+)
+
+func TestReplayWithoutCerts(t *testing.T) {
+	dir := t.TempDir()
+	// the TLS files of the config do not exist
+	config := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(config, []byte("cert: "+filepath.Join(dir, "dac.pem")+"\nkey: "+filepath.Join(dir, "dac-key.pem")+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{
+		filepath.Join("pkg", "adfunc", "testdata", "review-v1.json"),
+		filepath.Join("pkg", "adfunc", "testdata", "review-v1beta1.json"),
+	}
+	out, stderr, err := execute(t, "", append([]string{"replay", "--config", config, "--path", "/mutating/rename"}, files...)...)
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	for _, s := range []string{
+		"==> " + files[0] + ": /mutating/rename",
+		"==> " + files[1] + ": /mutating/rename",
+		"apiVersion: admission.k8s.io/v1\n",
+		"apiVersion: admission.k8s.io/v1beta1\n",
+		"uid: 705ab4f5-6393-11e8-b7cc-42010a800002",
+		"uid: 8b6b1a1c-6393-11e8-b7cc-42010a800002",
+		`{"op":"replace","path":"/spec/containers/0/image","value":"gcrxio/k8s.gcr.io_pause:3.6"}`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output has no %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestReplayRecordsWithoutCerts(t *testing.T) {
+	dir := t.TempDir()
+	// the TLS files of the config do not exist
+	config := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(config, []byte("cert: "+filepath.Join(dir, "dac.pem")+"\nkey: "+filepath.Join(dir, "dac-key.pem")+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records bytes.Buffer
+	for _, file := range []string{"review-v1.json", "review-v1beta1.json"} {
+		review, err := os.ReadFile(filepath.Join("pkg", "adfunc", "testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var compacted bytes.Buffer
+		if err = json.Compact(&compacted, review); err != nil {
+			t.Fatal(err)
+		}
+		line, err := json.Marshal(adfunc.Record{Time: time.Now(), Path: "/mutating/rename", Request: compacted.Bytes()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		records.Write(append(line, '\n'))
+	}
+	recordFile := filepath.Join(dir, "goadmission-20221018.jsonl")
+	if err = os.WriteFile(recordFile, records.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, stderr, err := execute(t, "", "replay", "--config", config, recordFile)
+	if err != nil {
+		t.Fatalf("%v: %s", err, stderr)
+	}
+	for _, s := range []string{
+		"==> " + recordFile + ":1: /mutating/rename",
+		"==> " + recordFile + ":2: /mutating/rename",
+		"apiVersion: admission.k8s.io/v1\n",
+		"apiVersion: admission.k8s.io/v1beta1\n",
+		"uid: 705ab4f5-6393-11e8-b7cc-42010a800002",
+		"uid: 8b6b1a1c-6393-11e8-b7cc-42010a800002",
+		`{"op":"replace","path":"/spec/containers/0/image","value":"gcrxio/k8s.gcr.io_pause:3.6"}`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output has no %q:\n%s", s, out)
+		}
+	}
+}
@@ -4,21 +4,24 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/mritd/goadmission/pkg/adfunc"
 
 	"github.com/mritd/goadmission/pkg/zaplogger"
 
+	"github.com/mritd/goadmission/pkg/certs"
 	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/kube"
 
-	"github.com/mritd/goadmission/pkg/route"
+	"github.com/mritd/goadmission/pkg/server"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 )
 
 var (
@@ -33,57 +36,64 @@ BuildCommit: %s
 `
 )
 
+// configFlags loads the config of the config file, the ConfigMap, the environment variables and the flags
+var configFlags conf.Flags
+var configWatchInterval time.Duration
+var kubeconfig string
+
+// kubeClient returns the kubernetes client of --kubeconfig, it is created once
+var kubeClient = sync.OnceValues(func() (kubernetes.Interface, error) {
+	return kube.NewClient(kubeconfig)
+})
+
+var certsManager = certs.Manager{}
+var manageCerts bool
+
 var rootCmd = &cobra.Command{
-	Use:     "goadmission",
-	Short:   "kubernetes dynamic admission control tool",
-	Version: buildCommit,
+	Use:           "goadmission",
+	Short:         "kubernetes dynamic admission control tool",
+	Version:       buildCommit,
+	SilenceErrors: true,
 	Run: func(cmd *cobra.Command, args []string) {
 		zaplogger.Setup()
-		adfunc.Setup()
-		route.Setup()
-
 		logger := zaplogger.NewSugar("main")
 
-		srv := &http.Server{
-			Handler: route.Router(),
-			Addr:    conf.Addr,
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := configFlags.WatchConfigMap(ctx, kubeClient, zaplogger.NewSugar("conf")); err != nil {
+			logger.Fatalf("failed to watch configmap: %v", err)
+		}
+		cfg, err := configFlags.Load()
+		if err != nil {
+			logger.Fatalf("failed to load config: %v", err)
 		}
 
-		sigs := make(chan os.Signal)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		go func() {
-			var shutdownOnce sync.Once
-			for range sigs {
-				logger.Warn("Receiving the termination signal, graceful shutdown...")
-				shutdownOnce.Do(func() {
-					err := srv.Shutdown(context.Background())
-					if err != nil {
-						logger.Error(err)
-					}
-				})
-			}
-		}()
-
-		if conf.Cert != "" && conf.Key != "" {
-			logger.Infof("Listen TLS Server at %s", conf.Addr)
-			err := srv.ListenAndServeTLS(conf.Cert, conf.Key)
-			if err != nil {
-				if err == http.ErrServerClosed {
-					logger.Info("server shutdown success.")
-				} else {
-					logger.Fatal(err)
-				}
+		opts := []server.Option{
+			server.WithConfig(cfg),
+			server.WithRegistry(adfunc.DefaultRegistry),
+			server.WithLogger(zaplogger.New("goadmission")),
+		}
+		if manageCerts {
+			if certsManager.Client, err = kubeClient(); err != nil {
+				logger.Fatal(err)
 			}
-		} else {
-			logger.Infof("Listen HTTP Server at %s", conf.Addr)
-			err := srv.ListenAndServe()
-			if err != nil {
-				if err == http.ErrServerClosed {
-					logger.Info("server shutdown success.")
-				} else {
-					logger.Fatal(err)
-				}
+			certsManager.Logger = zaplogger.NewSugar("certs")
+			if err = certsManager.Start(ctx); err != nil {
+				logger.Fatalf("failed to manage certificates: %v", err)
 			}
+			opts = append(opts, server.WithCertificate(certsManager.GetCertificate))
+		}
+
+		srv, err := server.New(opts...)
+		if err != nil {
+			logger.Fatalf("failed to create server: %v", err)
+		}
+
+		go configFlags.Watcher(configWatchInterval, srv.Reload, zaplogger.NewSugar("conf")).Run(ctx)
+
+		if err = srv.Run(ctx); err != nil {
+			logger.Fatal(err)
 		}
 	},
 }
@@ -100,18 +110,20 @@ func init() {
 	// version template
 	rootCmd.SetVersionTemplate(fmt.Sprintf(versionTpl, runtime.GOOS+"/"+runtime.GOARCH, buildDate, buildCommit))
 
-	// webhook
-	rootCmd.PersistentFlags().StringVarP(&conf.Addr, "listen", "l", ":443", "Admission Controller listen address")
-	rootCmd.PersistentFlags().StringVar(&conf.Cert, "cert", "", "Admission Controller TLS cert")
-	rootCmd.PersistentFlags().StringVar(&conf.Key, "key", "", "Admission Controller TLS cert key")
-
-	// adfunc image_rename
-	rootCmd.PersistentFlags().StringSliceVar(&conf.ImageRename, "image-rename", conf.DefaultImageRenameRules, "Pod image name rename rules")
-	// adfunc check_deploy_time
-	rootCmd.PersistentFlags().StringSliceVar(&conf.AllowDeployTime, "allow-deploy-time", conf.DefaultAllowDeployTime, "Allow deploy time")
-	rootCmd.PersistentFlags().StringVar(&conf.ForceDeployLabel, "force-deploy-label", conf.DefaultForceDeployLabel, "Force deploy label")
-	// adfunc disable_service_links
-	rootCmd.PersistentFlags().StringVar(&conf.ForceEnableServiceLinksLabel, "force-enable-service-links-label", conf.DefaultForceEnableServiceLinksLabel, "Force enable service links label")
+	// config sources and the config, the config flags override the config file and GOADMISSION_* environment variables
+	configFlags.AddFlags(rootCmd.PersistentFlags())
+	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "Kubeconfig file, the in-cluster config is used if it is empty")
+	rootCmd.Flags().DurationVar(&configWatchInterval, "config-watch-interval", conf.DefaultWatchInterval, "Config file polling interval, the config is reloaded when the file changes or SIGHUP is received (negative disables polling)")
+
+	// self-managed certificates
+	rootCmd.Flags().BoolVar(&manageCerts, "manage-certs", false, "Manage the CA and serving certificate in a Secret, rotate them before expiry and inject the caBundle into the webhook configurations, the TLS files are ignored")
+	rootCmd.Flags().StringVar(&certsManager.Namespace, "certs-namespace", "kube-addons", "Namespace of the certificate Secret and the webhook services")
+	rootCmd.Flags().StringVar(&certsManager.Secret, "certs-secret", "goadmission-certs", "Name of the certificate Secret")
+	rootCmd.Flags().StringSliceVar(&certsManager.Services, "certs-service", nil, "Webhook service names, the service DNS names are added to the certificate")
+	rootCmd.Flags().StringSliceVar(&certsManager.Hosts, "certs-host", nil, "Extra DNS names or IP addresses of the certificate")
+	rootCmd.Flags().StringSliceVar(&certsManager.MutatingWebhooks, "certs-mutating-webhook", nil, "MutatingWebhookConfiguration names to inject the caBundle into")
+	rootCmd.Flags().StringSliceVar(&certsManager.ValidatingWebhooks, "certs-validating-webhook", nil, "ValidatingWebhookConfiguration names to inject the caBundle into")
+	rootCmd.Flags().DurationVar(&certsManager.RotateBefore, "certs-rotate-before", certs.DefaultRotateBefore, "Rotate the certificates when they expire within the duration")
 }
 
 func main() {
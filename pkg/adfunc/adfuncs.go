@@ -1,23 +1,31 @@
 package adfunc
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/mritd/goadmission/pkg/zaplogger"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 
 	jsoniter "github.com/json-iterator/go"
 
+	"github.com/mritd/goadmission/pkg/conf"
 	"github.com/mritd/goadmission/pkg/route"
 
 	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const (
@@ -27,125 +35,275 @@ const (
 
 type AdmissionType string
 
+// Func is the signature of an admission control handler, the ctx carries the
+// config and logger of the dispatcher serving the request, and it is canceled
+// when the admission func times out or the apiserver gives up the request.
+// The fallback response is sent once ctx is done, so Func should return then.
+type Func func(ctx context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error)
+
 // AdmissionFunc defines an admission control handler
 type AdmissionFunc struct {
 	Type AdmissionType
 	Path string
-	Func func(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error)
+	// Kinds is the GroupVersionKinds handled by Func, "*" matches any group,
+	// version or kind, and "" is the core group. Empty means all kinds.
+	Kinds []schema.GroupVersionKind
+	// Operations is the operations handled by Func, empty means all operations.
+	Operations []admissionv1.Operation
+	// SubResources is the subresources handled by Func, "" is the main resource
+	// and "*" matches any subresource. Empty means only the main resource.
+	SubResources []string
+	// SideEffects is the side effect class of Func in the webhook configuration,
+	// empty means None.
+	SideEffects admissionregistrationv1.SideEffectClass
+	// Timeout limits the time of Func, the request timeout of the apiserver
+	// is used if it is shorter. Zero means only the request timeout.
+	Timeout time.Duration
+	// TimeoutFallback is the decision when Func times out, the request
+	// is allowed by default (DefaultTimeoutFallback).
+	TimeoutFallback *Fallback
+	// PanicFallback is the decision when Func panics, the request is denied
+	// by default (DefaultPanicFallback).
+	PanicFallback *Fallback
+	Func          Func
 }
 
-// admissionFuncMap is a collection of global admission control handlers
+// admissionFuncMap is a collection of admission control handlers
 type admissionFuncMap map[string]AdmissionFunc
 
-var funcMap = make(admissionFuncMap, 10)
+type loggerKey struct{}
 
-var adfuncOnce sync.Once
-var deserializer runtime.Decoder
-var logger *zap.SugaredLogger
+// Logger returns the logger of the dispatcher serving the request
+func Logger(ctx context.Context) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok && l != nil {
+		return l
+	}
+	return zap.NewNop().Sugar()
+}
 
-// Setup initialize deserializer and register admission control handlers
-// to the global routing handlers collection.
-func Setup() {
-	adfuncOnce.Do(func() {
-		logger = zaplogger.NewSugar("adfunc")
+// Dispatcher decodes admission reviews and dispatches them to the admission funcs of a registry
+type Dispatcher struct {
+	registry     *Registry
+	config       atomic.Pointer[conf.Config]
+	logger       *zap.SugaredLogger
+	deserializer runtime.Decoder
 
-		logger.Info("init kube deserializer...")
-		deserializer = serializer.NewCodecFactory(runtime.NewScheme()).UniversalDeserializer()
+	panicsMu sync.Mutex
+	panics   map[string]int64
 
-		logger.Info("init admission func...")
-		for p, af := range funcMap {
-			logger.Infof("load admission func: %s", af.Path)
-			handlePath := strings.Replace(p, "_", "-", -1)
-			if p != handlePath {
-				logger.Warnf("admission func handler path does not support '_', it has been automatically converted to '-'(%s => %s)", p, handlePath)
-			}
+	overdueMu sync.Mutex
+	overdue   map[string]int64
+
+	recorder recorder
+}
+
+// NewDispatcher returns a Dispatcher serving the registry, the config and logger
+// are passed to the admission funcs through the request context.
+func NewDispatcher(registry *Registry, config *conf.Config, logger *zap.SugaredLogger) *Dispatcher {
+	d := &Dispatcher{
+		registry:     registry,
+		logger:       logger,
+		deserializer: serializer.NewCodecFactory(reviewScheme).UniversalDeserializer(),
+		panics:       make(map[string]int64),
+		overdue:      make(map[string]int64),
+	}
+	d.config.Store(config)
+	return d
+}
+
+// Config returns the current config of the dispatcher
+func (d *Dispatcher) Config() *conf.Config {
+	return d.config.Load()
+}
+
+// Reload checks the config against the registry and swaps it in atomically, the requests
+// in flight keep the config they started with. The current config is kept if the config
+// is invalid.
+func (d *Dispatcher) Reload(config *conf.Config) error {
+	if err := d.check(config); err != nil {
+		return err
+	}
+	d.config.Store(config)
+	return nil
+}
+
+// Setup checks the registry and registers a http handler for every admission func
+// to the router. It returns the errors of invalid registrations, no more funcs can
+// be registered to the registry after Setup.
+func (d *Dispatcher) Setup(router *route.Router) error {
+	d.logger.Info("check admission func registry...")
+	if err := d.registry.seal(); err != nil {
+		return err
+	}
+	if err := d.Check(); err != nil {
+		return err
+	}
 
-			copyAf := af
-			route.RegisterHandler(route.HandleFunc{
-				Path:   handlePath,
-				Method: http.MethodPost,
-				Func: func(w http.ResponseWriter, r *http.Request) {
-					defer func() { _ = r.Body.Close() }()
-
-					reqBs, err := ioutil.ReadAll(r.Body)
-					if err != nil {
-						route.ResponseErr(handlePath, err.Error(), http.StatusInternalServerError, w)
-						return
-					}
-					if reqBs == nil || len(reqBs) == 0 {
-						route.ResponseErr(handlePath, "request body is empty", http.StatusBadRequest, w)
-						return
-					}
-					logger.Debugf("request body: %s", string(reqBs))
-
-					reqReview := admissionv1.AdmissionReview{}
-					if _, _, err := deserializer.Decode(reqBs, nil, &reqReview); err != nil {
-						route.ResponseErr(handlePath, fmt.Sprintf("failed to decode req: %s", err), http.StatusInternalServerError, w)
-						return
-					}
-					if reqReview.Request == nil {
-						route.ResponseErr(handlePath, "admission review request is empty", http.StatusBadRequest, w)
-						return
-					}
-
-					resp, err := copyAf.Func(reqReview.Request)
-					if err != nil {
-						route.ResponseErr(handlePath, fmt.Sprintf("admission func response: %s", err), http.StatusForbidden, w)
-						return
-					}
-					if resp == nil {
-						route.ResponseErr(handlePath, "admission func response is empty", http.StatusInternalServerError, w)
-						return
-					}
-					resp.UID = reqReview.Request.UID
-					respReview := admissionv1.AdmissionReview{
-						TypeMeta: reqReview.TypeMeta,
-						Response: resp,
-					}
-					respBs, err := jsoniter.Marshal(respReview)
-					if err != nil {
-						route.ResponseErr(handlePath, fmt.Sprintf("failed to marshal response: %s", err), http.StatusInternalServerError, w)
-						logger.Errorf("the expected response is: %v", respReview)
-						return
-					}
-
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusOK)
-					_, err = w.Write(respBs)
-					logger.Debugf("write response: %d: %s: %v", http.StatusOK, string(respBs), err)
-				},
-			})
-		}
-
-	})
+	d.logger.Info("init admission func...")
+	for p, af := range d.registry.Funcs() {
+		p, af := p, af
+		d.logger.Infof("load admission func: %s", af.Path)
+		if strings.Contains(af.Path, "_") {
+			d.logger.Warnf("admission func handler path does not support '_', it has been automatically converted to '-'(%s => %s)", af.Path, p)
+		}
+
+		err := router.RegisterHandler(route.HandleFunc{
+			Path:   p,
+			Method: http.MethodPost,
+			Func: d.handler(p, func(ctx context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+				return d.admit(ctx, p, af, request)
+			}),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return d.setupPipelines(router)
+}
+
+// Check checks the config against the registry, e.g. the runtime settings of
+// unregistered admission funcs and the pipelines of unknown stages. All errors
+// are returned.
+func (d *Dispatcher) Check() error {
+	return d.check(d.Config())
 }
 
-func register(af AdmissionFunc) {
-	if af.Path == "" {
-		logger.Fatalf("admission func path is empty")
+func (d *Dispatcher) check(config *conf.Config) error {
+	errs := []error{d.registry.Err(), config.Validate()}
+	funcs := d.registry.Funcs()
+	for p, fc := range config.Funcs {
+		if _, ok := funcs[p]; !ok {
+			errs = append(errs, fmt.Errorf("funcs: admission func [%s] is not registered", p))
+			continue
+		}
+		if _, err := ParseMode(fc.Mode); err != nil {
+			errs = append(errs, fmt.Errorf("funcs: admission func [%s]: %w", p, err))
+		}
+		if fc.Timeout != "" {
+			if _, err := time.ParseDuration(fc.Timeout); err != nil {
+				errs = append(errs, fmt.Errorf("funcs: admission func [%s]: invalid timeout: %w", p, err))
+			}
+		}
+		if fc.TimeoutFallback != "" {
+			if _, err := ParseFallback(fc.TimeoutFallback); err != nil {
+				errs = append(errs, fmt.Errorf("funcs: admission func [%s]: invalid timeout fallback: %w", p, err))
+			}
+		}
+		if fc.PanicFallback != "" {
+			if _, err := ParseFallback(fc.PanicFallback); err != nil {
+				errs = append(errs, fmt.Errorf("funcs: admission func [%s]: invalid panic fallback: %w", p, err))
+			}
+		}
+	}
+	pipelines, err := d.pipelines(config)
+	if err != nil {
+		errs = append(errs, err)
 	}
+	for _, p := range config.Record.Funcs {
+		_, isFunc := funcs[p]
+		_, isPipeline := pipelines[p]
+		if !isFunc && !isPipeline {
+			errs = append(errs, fmt.Errorf("record.funcs: admission func or pipeline [%s] is not registered", p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handler builds the http handler that decodes the admission review and calls admit,
+// the current config is passed to admit through the request context.
+func (d *Dispatcher) handler(handlePath string, admit Func) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+
+		reqBs, err := io.ReadAll(r.Body)
+		if err != nil {
+			route.ResponseErr(d.logger, handlePath, err.Error(), http.StatusInternalServerError, w)
+			return
+		}
+		if len(reqBs) == 0 {
+			route.ResponseErr(d.logger, handlePath, "request body is empty", http.StatusBadRequest, w)
+			return
+		}
+		cfg := d.Config()
+		debug := d.logger.Desugar().Core().Enabled(zapcore.DebugLevel)
+		if debug {
+			d.logger.Debugf("request body: %s", logRedactor(cfg).Review(reqBs))
+		}
+
+		reqReview, reviewGVK, err := decodeReview(d.deserializer, reqBs)
+		if err != nil {
+			route.ResponseErr(d.logger, handlePath, fmt.Sprintf("failed to decode req: %s", err), http.StatusInternalServerError, w)
+			return
+		}
+		if reqReview.Request == nil {
+			d.respond(w, handlePath, reviewGVK, "", d.deny(handlePath, http.StatusBadRequest, "admission review request is empty"))
+			return
+		}
 
-	if af.Type == "" {
-		logger.Fatalf("admission func type is empty")
+		var respBs []byte
+		defer func() { d.record(cfg, handlePath, reqReview.Request.Kind.Kind, reqBs, respBs) }()
+
+		ctx := conf.NewContext(r.Context(), cfg)
+		if t, ok := requestTimeout(r); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, t)
+			defer cancel()
+		}
+		resp, err := admit(ctx, reqReview.Request)
+		if err != nil {
+			resp = d.deny(handlePath, http.StatusForbidden, fmt.Sprintf("admission func response: %s", err))
+		} else if resp == nil {
+			resp = d.deny(handlePath, http.StatusInternalServerError, "admission func response is empty")
+		}
+		respBs = d.respond(w, handlePath, reviewGVK, reqReview.Request.UID, resp)
+		if debug && respBs != nil {
+			d.logger.Debugf("write response: %d: %s", http.StatusOK, logRedactor(cfg).Response(respBs, reqReview.Request.Kind.Kind))
+		}
 	}
+}
+
+// deny logs the error of the handle path and returns the response that denies the request
+func (d *Dispatcher) deny(handlePath string, code int32, msg string) *admissionv1.AdmissionResponse {
+	d.logger.Errorf("handle func [%s] response err: %s", handlePath, msg)
+	return Denied(code, msg)
+}
 
-	handlePath := strings.ToLower(af.Path)
-	if !strings.HasPrefix(handlePath, "/") {
-		handlePath = "/" + handlePath
+// respond writes the response as an AdmissionReview of the request version with the request
+// uid, the apiserver only accepts it with http 200. It returns the written AdmissionReview,
+// or nil if the response can not be encoded.
+func (d *Dispatcher) respond(w http.ResponseWriter, handlePath string, gvk *schema.GroupVersionKind, uid types.UID, resp *admissionv1.AdmissionResponse) []byte {
+	resp.UID = uid
+	respReview, err := encodeReview(gvk, resp)
+	if err != nil {
+		route.ResponseErr(d.logger, handlePath, fmt.Sprintf("failed to encode response: %s", err), http.StatusInternalServerError, w)
+		return nil
+	}
+	respBs, err := jsoniter.Marshal(respReview)
+	if err != nil {
+		route.ResponseErr(d.logger, handlePath, fmt.Sprintf("failed to marshal response: %s", err), http.StatusInternalServerError, w)
+		return nil
 	}
-	switch af.Type {
-	case AdmissionTypeMutating:
-		handlePath = "/mutating" + handlePath
-	case AdmissionTypeValidating:
-		handlePath = "/validating" + handlePath
-	default:
-		logger.Fatalf("unsupported admission func type")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(respBs); err != nil {
+		d.logger.Errorf("handle func [%s] failed to write response: %v", handlePath, err)
 	}
+	return respBs
+}
 
-	registeredAf, exist := funcMap[handlePath]
-	if exist && registeredAf.Type == af.Type {
-		logger.Fatalf("admission func [%s], type: %s already registered", af.Path, af.Type)
+// admit calls the admission func with the config carried by ctx and the logger of the
+// dispatcher, the ctx of the admission func is canceled at the deadline of the request or
+// the timeout of the admission func. The decision is enforced according to the mode of
+// the admission func.
+func (d *Dispatcher) admit(ctx context.Context, handlePath string, af AdmissionFunc, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	if !af.inScope(request) {
+		d.logger.Infof("[route.%s] %s: %s %s %s/%s (subresource: %q) is out of scope, allowed unchanged",
+			af.Type, handlePath, request.Operation, request.Kind, request.Namespace, request.Name, request.SubResource)
+		return Allowed("out of scope"), nil
 	}
 
-	funcMap[handlePath] = af
+	cfg := conf.FromContext(ctx)
+	ctx = context.WithValue(ctx, loggerKey{}, d.logger.With("path", handlePath))
+	resp, err := d.callWithDeadline(ctx, cfg, handlePath, af, request)
+	return d.applyMode(cfg, handlePath, request, resp, err)
 }
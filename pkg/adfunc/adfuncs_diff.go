@@ -0,0 +1,124 @@
+package adfunc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"gomodules.xyz/jsonpatch/v2"
+)
+
+// DiffPatch computes the RFC 6902 patches that turn the raw object into the mutated object.
+// original must be the object decoded from raw, both objects are marshaled the same way so
+// that the fields dropped or defaulted by decoding never show up in the patches. Patches are
+// rewritten to apply to raw, e.g. a new annotation is added as "/metadata/annotations" when
+// raw has no annotations, otherwise as "/metadata/annotations/<key>".
+func DiffPatch(raw []byte, original, mutated interface{}) ([]Patch, error) {
+	originalBs, err := jsoniter.Marshal(original)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal original object: %w", err)
+	}
+	mutatedBs, err := jsoniter.Marshal(mutated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal mutated object: %w", err)
+	}
+	ops, err := jsonpatch.CreatePatch(originalBs, mutatedBs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff objects: %w", err)
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	var rawDoc, mutatedDoc interface{}
+	if err = jsoniter.Unmarshal(raw, &rawDoc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal raw object: %w", err)
+	}
+	if err = jsoniter.Unmarshal(mutatedBs, &mutatedDoc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mutated object: %w", err)
+	}
+
+	patches := make([]Patch, 0, len(ops))
+	added := make(map[string]bool)
+	for _, op := range ops {
+		tokens := parsePointer(op.Path)
+		// find the first token of the path that does not exist in raw
+		missing := 0
+		for missing < len(tokens) {
+			if _, ok := lookupPointer(rawDoc, tokens[:missing+1]); !ok {
+				break
+			}
+			missing++
+		}
+
+		switch {
+		case missing == len(tokens):
+			// the path exists in raw, keep the operation as it is
+			patches = append(patches, Patch{Option: PatchOption(op.Operation), Path: op.Path, Value: op.Value})
+		case missing == len(tokens)-1 && op.Operation != string(PatchOptionRemove):
+			// only the last token is missing, the value is added to its parent
+			patches = append(patches, Patch{Option: PatchOptionAdd, Path: op.Path, Value: op.Value})
+		default:
+			// the parent does not exist in raw, add the whole subtree from the mutated object
+			subPath := formatPointer(tokens[:missing+1])
+			if added[subPath] {
+				continue
+			}
+			value, ok := lookupPointer(mutatedDoc, tokens[:missing+1])
+			if !ok {
+				// removed from the mutated object, and it never existed in raw
+				continue
+			}
+			added[subPath] = true
+			patches = append(patches, Patch{Option: PatchOptionAdd, Path: subPath, Value: value})
+		}
+	}
+	return patches, nil
+}
+
+// parsePointer splits the RFC 6901 json pointer into unescaped tokens
+func parsePointer(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
+
+// formatPointer joins and escapes the tokens into a RFC 6901 json pointer
+func formatPointer(tokens []string) string {
+	var sb strings.Builder
+	for _, t := range tokens {
+		sb.WriteString("/")
+		sb.WriteString(strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1"))
+	}
+	return sb.String()
+}
+
+// lookupPointer returns the value of the tokens in a unmarshalled json document
+func lookupPointer(doc interface{}, tokens []string) (interface{}, bool) {
+	current := doc
+	for _, t := range tokens {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			next, ok := v[t]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			current = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
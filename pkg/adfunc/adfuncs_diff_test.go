@@ -0,0 +1,187 @@
+package adfunc
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+func TestDiffPatch(t *testing.T) {
+	const pod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","labels":{"app":"nginx","tier":"web"}},
+"spec":{"containers":[{"name":"nginx","image":"nginx:1.21"}]},"unknown":{"kept":true}}`
+	const annotatedPod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","annotations":{"a":"b"}},
+"spec":{"containers":[{"name":"nginx","image":"nginx:1.21","env":[{"name":"A","value":"1"}]}]}}`
+
+	// defaultedPod has the empty and defaulted fields set by the apiserver
+	const defaultedPod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","creationTimestamp":null},
+"spec":{"restartPolicy":"Always","dnsPolicy":"ClusterFirst","containers":[{"name":"nginx","image":"nginx:1.21","resources":{},
+"terminationMessagePath":"/dev/termination-log","imagePullPolicy":"IfNotPresent"}]},"status":{}}`
+
+	falseVal := false
+	tests := []struct {
+		name   string
+		raw    string
+		mutate func(pod *corev1.Pod)
+		// paths is the paths of the expected patches, nil means any
+		paths []string
+	}{
+		{
+			name:   "unchanged",
+			raw:    pod,
+			mutate: func(pod *corev1.Pod) {},
+			paths:  []string{},
+		},
+		{
+			name:   "replace image",
+			raw:    pod,
+			mutate: func(pod *corev1.Pod) { pod.Spec.Containers[0].Image = "nginx:1.23" },
+			paths:  []string{"/spec/containers/0/image"},
+		},
+		{
+			name: "add annotations omitted in raw",
+			raw:  pod,
+			mutate: func(pod *corev1.Pod) {
+				pod.Annotations = map[string]string{"mritd.com/renamed": "true"}
+			},
+			paths: []string{"/metadata/annotations"},
+		},
+		{
+			name: "add escaped annotation key",
+			raw:  annotatedPod,
+			mutate: func(pod *corev1.Pod) {
+				pod.Annotations["mritd.com/renamed~1"] = "true"
+			},
+			paths: []string{"/metadata/annotations/mritd.com~1renamed~01"},
+		},
+		{
+			name:   "replace image with defaulted fields",
+			raw:    defaultedPod,
+			mutate: func(pod *corev1.Pod) { pod.Spec.Containers[0].Image = "nginx:1.23" },
+			paths:  []string{"/spec/containers/0/image"},
+		},
+		{
+			name:   "change defaulted field",
+			raw:    defaultedPod,
+			mutate: func(pod *corev1.Pod) { pod.Spec.Containers[0].ImagePullPolicy = corev1.PullAlways },
+			paths:  []string{"/spec/containers/0/imagePullPolicy"},
+		},
+		{
+			name:   "set pointer field omitted in raw",
+			raw:    pod,
+			mutate: func(pod *corev1.Pod) { pod.Spec.EnableServiceLinks = &falseVal },
+			paths:  []string{"/spec/enableServiceLinks"},
+		},
+		{
+			name:   "remove label",
+			raw:    pod,
+			mutate: func(pod *corev1.Pod) { delete(pod.Labels, "tier") },
+			paths:  []string{"/metadata/labels/tier"},
+		},
+		{
+			name: "add env to container without env",
+			raw:  pod,
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "A", Value: "1"}}
+			},
+			paths: []string{"/spec/containers/0/env"},
+		},
+		{
+			name: "append env",
+			raw:  annotatedPod,
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "B", Value: "2"})
+			},
+			paths: []string{"/spec/containers/0/env/1"},
+		},
+		{
+			name: "add container",
+			raw:  pod,
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "sidecar", Image: "busybox"})
+			},
+		},
+		{
+			name: "add nested fields omitted in raw",
+			raw:  pod,
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.SecurityContext = &corev1.PodSecurityContext{RunAsNonRoot: &falseVal}
+				pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Privileged: &falseVal}
+			},
+			paths: []string{"/spec/containers/0/securityContext", "/spec/securityContext"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := []byte(tt.raw)
+			original := &corev1.Pod{}
+			if err := json.Unmarshal(raw, original); err != nil {
+				t.Fatal(err)
+			}
+			mutated := original.DeepCopy()
+			tt.mutate(mutated)
+
+			patches, err := DiffPatch(raw, original, mutated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.paths != nil {
+				got := make(map[string]bool, len(patches))
+				for _, p := range patches {
+					got[p.Path] = true
+				}
+				if len(got) != len(tt.paths) {
+					t.Errorf("got patches %+v, want paths %v", patches, tt.paths)
+				}
+				for _, p := range tt.paths {
+					if !got[p] {
+						t.Errorf("got patches %+v, want path %s", patches, p)
+					}
+				}
+			}
+			if len(patches) == 0 {
+				return
+			}
+
+			bs, err := json.Marshal(patches)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ops, err := jsonpatch.DecodePatch(bs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patchedRaw, err := ops.Apply(raw)
+			if err != nil {
+				t.Fatalf("failed to apply %s to raw object: %v", bs, err)
+			}
+			patched := &corev1.Pod{}
+			if err = json.Unmarshal(patchedRaw, patched); err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(patched, mutated) {
+				t.Errorf("patched raw object does not match the mutated object\npatch: %s\npatched: %s", bs, patchedRaw)
+			}
+
+			// the fields omitted in raw or unknown to the typed object are left as they are
+			var doc map[string]interface{}
+			if err = json.Unmarshal(patchedRaw, &doc); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := doc["status"]; ok && tt.raw != defaultedPod {
+				t.Errorf("patch adds the status omitted in raw: %s", bs)
+			}
+			metadata, _ := doc["metadata"].(map[string]interface{})
+			if _, ok := metadata["creationTimestamp"]; ok && tt.raw != defaultedPod {
+				t.Errorf("patch adds the creationTimestamp omitted in raw: %s", bs)
+			}
+			if tt.raw == pod {
+				if _, ok := doc["unknown"]; !ok {
+					t.Errorf("patch drops the unknown field of raw: %s", bs)
+				}
+			}
+		})
+	}
+}
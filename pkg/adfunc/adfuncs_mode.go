@@ -0,0 +1,93 @@
+package adfunc
+
+import (
+	"fmt"
+
+	"github.com/mritd/goadmission/pkg/conf"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Mode controls how the decision of an admission func is enforced
+type Mode string
+
+const (
+	// ModeEnforce returns the decision of the admission func as it is
+	ModeEnforce Mode = "enforce"
+	// ModeWarn allows denied requests and returns the denial message as a warning
+	ModeWarn Mode = "warn"
+	// ModeAudit allows denied requests, the denial is logged and recorded in the audit annotations
+	ModeAudit Mode = "audit"
+	// ModeDryRun evaluates the admission func and logs the decision, but never denies or patches
+	ModeDryRun Mode = "dry-run"
+)
+
+// ParseMode parses the mode name, an empty name is ModeEnforce
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case "":
+		return ModeEnforce, nil
+	case ModeEnforce, ModeWarn, ModeAudit, ModeDryRun:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unsupported admission func mode: %s", s)
+	}
+}
+
+// mode returns the configured mode of the admission func
+func (d *Dispatcher) mode(cfg *conf.Config, handlePath string) Mode {
+	m, err := ParseMode(cfg.Func(handlePath).Mode)
+	if err != nil {
+		return ModeEnforce
+	}
+	return m
+}
+
+// applyMode turns the decision of the admission func into the response of the mode,
+// funcErr is the error returned by the admission func.
+func (d *Dispatcher) applyMode(cfg *conf.Config, handlePath string, request *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse, funcErr error) (*admissionv1.AdmissionResponse, error) {
+	mode := d.mode(cfg, handlePath)
+	if mode == ModeEnforce {
+		return resp, funcErr
+	}
+
+	var denied bool
+	var msg string
+	switch {
+	case funcErr != nil:
+		denied, msg = true, funcErr.Error()
+	case resp == nil:
+		return resp, funcErr
+	case !resp.Allowed:
+		denied, msg = true, "denied"
+		if resp.Result != nil && resp.Result.Message != "" {
+			msg = resp.Result.Message
+		}
+	}
+
+	switch mode {
+	case ModeWarn:
+		if !denied {
+			return resp, nil
+		}
+		d.logger.Warnf("[%s] %s: %s %s/%s denied in warn mode: %s", mode, handlePath, request.Kind.Kind, request.Namespace, request.Name, msg)
+		warned := Allowed(msg)
+		warned.Warnings = []string{msg}
+		return warned, nil
+	case ModeAudit:
+		if !denied {
+			return resp, nil
+		}
+		d.logger.Warnf("[%s] %s: %s %s/%s denied in audit mode: %s", mode, handlePath, request.Kind.Kind, request.Namespace, request.Name, msg)
+		audited := Allowed(msg)
+		audited.AuditAnnotations = map[string]string{"audit-denied": msg}
+		return audited, nil
+	default:
+		if denied {
+			d.logger.Infof("[%s] %s: %s %s/%s would be denied: %s", mode, handlePath, request.Kind.Kind, request.Namespace, request.Name, msg)
+		} else {
+			d.logger.Infof("[%s] %s: %s %s/%s would be allowed, patch: %s", mode, handlePath, request.Kind.Kind, request.Namespace, request.Name, logRedactor(cfg).Patch(resp.Patch, request.Kind.Kind))
+		}
+		return Allowed("dry-run"), nil
+	}
+}
@@ -0,0 +1,89 @@
+package adfunc
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+const modePod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","namespace":"default"},
+"spec":{"containers":[{"name":"nginx","image":"nginx:1.21"}]}}`
+
+// modeRegistry returns a registry of "/validating/deny" that denies any request and
+// "/mutating/label" that labels the pod
+func modeRegistry() *Registry {
+	registry := NewRegistry()
+	registry.MustRegister(AdmissionFunc{
+		Type: AdmissionTypeValidating,
+		Path: "deny",
+		Func: func(_ context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			return Denied(http.StatusForbidden, "not in the deploy window"), nil
+		},
+	})
+	registry.MustRegister(AdmissionFunc{
+		Type: AdmissionTypeMutating,
+		Path: "label",
+		Func: Mutate(func(_ context.Context, _ *admissionv1.AdmissionRequest, pod *corev1.Pod) (*corev1.Pod, error) {
+			pod.Labels = map[string]string{"mode": "test"}
+			return pod, nil
+		}),
+	})
+	return registry
+}
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		mode    string
+		path    string
+		allowed bool
+		warning string
+		audit   string
+		patched bool
+	}{
+		{mode: "enforce", path: "/validating/deny", allowed: false},
+		{mode: "warn", path: "/validating/deny", allowed: true, warning: "not in the deploy window"},
+		{mode: "audit", path: "/validating/deny", allowed: true, audit: "not in the deploy window"},
+		{mode: "dry-run", path: "/validating/deny", allowed: true},
+		{mode: "enforce", path: "/mutating/label", allowed: true, patched: true},
+		{mode: "warn", path: "/mutating/label", allowed: true, patched: true},
+		{mode: "audit", path: "/mutating/label", allowed: true, patched: true},
+		{mode: "dry-run", path: "/mutating/label", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+tt.path, func(t *testing.T) {
+			cfg := conf.Default()
+			cfg.Funcs[tt.path] = conf.FuncConfig{Mode: tt.mode}
+			handler := newTestHandler(t, modeRegistry(), cfg)
+
+			resp := reviewResponse(t, post(t, handler, tt.path, testReview(t, "Pod", modePod)))
+			if resp.Allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v", resp.Allowed, tt.allowed)
+			}
+			if tt.warning == "" && len(resp.Warnings) > 0 || tt.warning != "" && (len(resp.Warnings) != 1 || resp.Warnings[0] != tt.warning) {
+				t.Errorf("warnings = %v, want %q", resp.Warnings, tt.warning)
+			}
+			if got := resp.AuditAnnotations["audit-denied"]; got != tt.audit {
+				t.Errorf("audit-denied annotation = %q, want %q", got, tt.audit)
+			}
+			if patched := len(resp.Patch) > 0; patched != tt.patched {
+				t.Errorf("patch = %s, want patched %v", resp.Patch, tt.patched)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]Mode{"": ModeEnforce, "enforce": ModeEnforce, "warn": ModeWarn, "audit": ModeAudit, "dry-run": ModeDryRun} {
+		if m, err := ParseMode(s); err != nil || m != want {
+			t.Errorf("ParseMode(%q) = %s, %v, want %s", s, m, err, want)
+		}
+	}
+	if _, err := ParseMode("dryrun"); err == nil {
+		t.Error("unsupported mode is parsed")
+	}
+}
@@ -0,0 +1,152 @@
+package adfunc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+
+	"github.com/mritd/goadmission/pkg/conf"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// NewRequest returns a synthetic admission request of the object, so that the admission
+// funcs can run on manifests without the apiserver. The object is the old object of
+// DELETE requests, and both the object and the old object of UPDATE requests.
+func NewRequest(obj *unstructured.Unstructured, operation admissionv1.Operation, userInfo authenticationv1.UserInfo) (*admissionv1.AdmissionRequest, error) {
+	raw, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	gvk := obj.GroupVersionKind()
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	dryRun := true
+
+	request := &admissionv1.AdmissionRequest{
+		UID:             uuid.NewUUID(),
+		Kind:            metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+		Resource:        metav1.GroupVersionResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource},
+		Name:            obj.GetName(),
+		Namespace:       obj.GetNamespace(),
+		Operation:       operation,
+		UserInfo:        userInfo,
+		DryRun:          &dryRun,
+		RequestKind:     &metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+		RequestResource: &metav1.GroupVersionResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource},
+	}
+	switch operation {
+	case admissionv1.Delete:
+		request.OldObject = runtime.RawExtension{Raw: raw}
+	case admissionv1.Update:
+		request.Object = runtime.RawExtension{Raw: raw}
+		request.OldObject = runtime.RawExtension{Raw: raw}
+	default:
+		request.Object = runtime.RawExtension{Raw: raw}
+	}
+	return request, nil
+}
+
+// FuncPaths resolves the handle paths of the admission funcs of the type by name, e.g. "rename"
+// or "/mutating/rename", the order of names is kept. All funcs of the type are returned in
+// handle path order if names is empty.
+func (d *Dispatcher) FuncPaths(typ AdmissionType, names []string) ([]string, error) {
+	funcs := d.registry.Funcs()
+	if len(names) == 0 {
+		var paths []string
+		for p, af := range funcs {
+			if af.Type == typ {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		return paths, nil
+	}
+
+	stages, err := stagesOf(funcs, typ, names)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		paths = append(paths, stage.handlePath)
+	}
+	return paths, nil
+}
+
+// Result is the decision of an admission func on a request
+type Result struct {
+	Path string
+	// InScope reports whether the request is in the scope of the admission func,
+	// the admission func is not called if it is false.
+	InScope  bool
+	Response *admissionv1.AdmissionResponse
+	Err      error
+}
+
+// Review calls the admission funcs of the handle paths with the current config, as the
+// webhook serves the request, including the scope, mode, timeout and panic handling.
+func (d *Dispatcher) Review(ctx context.Context, paths []string, request *admissionv1.AdmissionRequest) []Result {
+	funcs := d.registry.Funcs()
+	ctx = conf.NewContext(ctx, d.Config())
+	results := make([]Result, 0, len(paths))
+	for _, p := range paths {
+		af, ok := funcs[p]
+		if !ok {
+			results = append(results, Result{Path: p, Err: fmt.Errorf("admission func [%s] is not registered", p)})
+			continue
+		}
+		if !af.inScope(request) {
+			results = append(results, Result{Path: p})
+			continue
+		}
+		resp, err := d.admit(ctx, p, af, request)
+		if err == nil && resp == nil {
+			err = errors.New("admission func response is empty")
+		}
+		results = append(results, Result{Path: p, InScope: true, Response: resp, Err: err})
+	}
+	return results
+}
+
+// Mutate calls the mutating admission funcs of the handle paths in order like a pipeline,
+// and returns the merged response and the patched object. The object is returned as it is
+// if the request is denied or not patched.
+func (d *Dispatcher) Mutate(ctx context.Context, paths []string, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, []byte, error) {
+	funcs := d.registry.Funcs()
+	stages := make([]pipelineStage, 0, len(paths))
+	for _, p := range paths {
+		af, ok := funcs[p]
+		if !ok || af.Type != AdmissionTypeMutating {
+			return nil, nil, fmt.Errorf("mutating admission func [%s] is not registered", p)
+		}
+		stages = append(stages, pipelineStage{handlePath: p, af: af})
+	}
+
+	ctx = conf.NewContext(ctx, d.Config())
+	resp, err := d.admitPipeline(ctx, "offline", stages, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	object := request.Object.Raw
+	if !resp.Allowed || len(resp.Patch) == 0 {
+		return resp, object, nil
+	}
+	patch, err := jsonpatch.DecodePatch(resp.Patch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode patch: %w", err)
+	}
+	patched, err := patch.Apply(object)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to apply patch: %w", err)
+	}
+	return resp, patched, nil
+}
@@ -0,0 +1,61 @@
+package adfunc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/mritd/goadmission/pkg/conf"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// DefaultPanicFallback denies the request when the admission func panics
+var DefaultPanicFallback = Fallback{Allowed: false}
+
+// call calls the admission func, the panic of the admission func is recovered
+func call(ctx context.Context, af AdmissionFunc, request *admissionv1.AdmissionRequest) (r funcResult) {
+	defer func() {
+		if p := recover(); p != nil {
+			r = funcResult{panicked: p, stack: debug.Stack()}
+		}
+	}()
+	resp, err := af.Func(ctx, request)
+	return funcResult{resp: resp, err: err}
+}
+
+// panicFallback returns the configured panic fallback of the admission func
+func (d *Dispatcher) panicFallback(cfg *conf.Config, handlePath string, af AdmissionFunc) Fallback {
+	if fb, err := ParseFallback(cfg.Func(handlePath).PanicFallback); err == nil {
+		return fb
+	}
+	if af.PanicFallback != nil {
+		return *af.PanicFallback
+	}
+	return DefaultPanicFallback
+}
+
+// recovered counts and logs the panic of the admission func, and returns the fallback response
+func (d *Dispatcher) recovered(cfg *conf.Config, handlePath string, af AdmissionFunc, request *admissionv1.AdmissionRequest, r funcResult) *admissionv1.AdmissionResponse {
+	d.panicsMu.Lock()
+	d.panics[handlePath]++
+	count := d.panics[handlePath]
+	d.panicsMu.Unlock()
+
+	fb := d.panicFallback(cfg, handlePath, af)
+	d.logger.Errorf("[route.%s] %s: admission func panicked(total: %d) on %s %s %s/%s, fallback allowed: %t, err: %v, trace: %s",
+		af.Type, handlePath, count, request.Operation, request.Kind.Kind, request.Namespace, request.Name, fb.Allowed, r.panicked, string(r.stack))
+	return fb.response(http.StatusInternalServerError, fmt.Sprintf("admission func %s panicked: %v", handlePath, r.panicked))
+}
+
+// Panics returns the number of panics of every admission func keyed by handle path
+func (d *Dispatcher) Panics() map[string]int64 {
+	d.panicsMu.Lock()
+	defer d.panicsMu.Unlock()
+	panics := make(map[string]int64, len(d.panics))
+	for p, c := range d.panics {
+		panics[p] = c
+	}
+	return panics
+}
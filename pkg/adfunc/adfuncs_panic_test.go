@@ -0,0 +1,114 @@
+package adfunc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/route"
+)
+
+// panicRegistry returns a registry of "/validating/panic" that panics and
+// "/validating/error" that returns an error
+func panicRegistry() *Registry {
+	registry := NewRegistry()
+	registry.MustRegister(AdmissionFunc{
+		Type: AdmissionTypeValidating,
+		Path: "panic",
+		Func: func(_ context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			var labels map[string]string
+			labels["panic"] = "true"
+			return Allowed("success"), nil
+		},
+	})
+	registry.MustRegister(AdmissionFunc{
+		Type: AdmissionTypeValidating,
+		Path: "error",
+		Func: func(_ context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			return nil, errors.New("backend is unavailable")
+		},
+	})
+	return registry
+}
+
+func TestPanicRecovered(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		allowed  bool
+		code     int32
+	}{
+		{"default", "", false, http.StatusInternalServerError},
+		{"allow", "allow", true, http.StatusOK},
+		{"deny", "deny:rejected by panic", false, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := conf.Default()
+			cfg.Funcs["/validating/panic"] = conf.FuncConfig{PanicFallback: tt.fallback}
+			logger := zap.NewNop().Sugar()
+			router := route.NewRouter(logger)
+			d := NewDispatcher(panicRegistry(), cfg, logger)
+			if err := d.Setup(router); err != nil {
+				t.Fatal(err)
+			}
+			handler := router.Handler()
+
+			for i := 1; i <= 2; i++ {
+				resp := reviewResponse(t, post(t, handler, "/validating/panic", testReview(t, "Pod", modePod)))
+				if resp.UID != testUID {
+					t.Errorf("uid = %s, want the uid of the request", resp.UID)
+				}
+				if resp.Allowed != tt.allowed || resp.Result == nil || resp.Result.Code != tt.code {
+					t.Errorf("response = %v, want allowed %v with %d", resp, tt.allowed, tt.code)
+				}
+				if strings.HasPrefix(tt.fallback, "deny:") && resp.Result.Message != "rejected by panic" {
+					t.Errorf("message = %q, want the message of the fallback", resp.Result.Message)
+				}
+				if panics := d.Panics()["/validating/panic"]; panics != int64(i) {
+					t.Errorf("panics = %d, want %d", panics, i)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerErrorResponse(t *testing.T) {
+	handler := newTestHandler(t, panicRegistry(), conf.Default())
+	tests := []struct {
+		file       string
+		apiVersion string
+		uid        string
+	}{
+		{"testdata/review-v1.json", "admission.k8s.io/v1", "705ab4f5-6393-11e8-b7cc-42010a800002"},
+		{"testdata/review-v1beta1.json", "admission.k8s.io/v1beta1", "8b6b1a1c-6393-11e8-b7cc-42010a800002"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.apiVersion, func(t *testing.T) {
+			body, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// post fails unless the response is sent with http 200
+			review := post(t, handler, "/validating/error", body)
+			if review["apiVersion"] != tt.apiVersion || review["kind"] != "AdmissionReview" {
+				t.Errorf("got %v %v, want %s AdmissionReview", review["apiVersion"], review["kind"], tt.apiVersion)
+			}
+			resp := reviewResponse(t, review)
+			if resp.UID != types.UID(tt.uid) || resp.Allowed {
+				t.Errorf("response = %v, want the denial of the request", resp)
+			}
+			if resp.Result == nil || !strings.Contains(resp.Result.Message, "backend is unavailable") {
+				t.Errorf("result = %v, want the error of the admission func", resp.Result)
+			}
+		})
+	}
+}
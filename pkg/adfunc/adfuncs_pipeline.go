@@ -0,0 +1,220 @@
+package adfunc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gorilla/mux"
+
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/route"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// pipelineStage is a mutating admission func of a pipeline
+type pipelineStage struct {
+	handlePath string
+	af         AdmissionFunc
+}
+
+// pipelineRoute serves all mutating pipelines, the stages are resolved from the
+// config of every request, so that pipelines can be changed by reloading the config.
+const pipelineRoute = "/mutating/pipeline/{name}"
+
+// PipelinePath returns the handle path of the named mutating pipeline
+func PipelinePath(name string) string {
+	return "/mutating/pipeline/" + strings.ToLower(name)
+}
+
+// pipelines resolves the stages of the configured pipelines keyed by handle path, the
+// stages are the paths of registered mutating funcs, e.g. "rename" or "/mutating/rename".
+func (d *Dispatcher) pipelines(cfg *conf.Config) (map[string][]pipelineStage, error) {
+	funcs := d.registry.Funcs()
+	pipelines := make(map[string][]pipelineStage, len(cfg.Pipelines))
+	var errs []error
+	for name, stageNames := range cfg.Pipelines {
+		handlePath := PipelinePath(name)
+		if !handlePathRegexp.MatchString(handlePath) {
+			errs = append(errs, fmt.Errorf("pipeline name is invalid: %s", name))
+			continue
+		}
+		if len(stageNames) == 0 {
+			errs = append(errs, fmt.Errorf("pipeline [%s] has no stage", name))
+			continue
+		}
+
+		stages, err := stagesOf(funcs, AdmissionTypeMutating, stageNames)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pipeline [%s]: %w", name, err))
+		}
+		pipelines[handlePath] = stages
+	}
+	return pipelines, errors.Join(errs...)
+}
+
+// stagesOf resolves the admission funcs of the type by name, e.g. "rename" or "/mutating/rename"
+func stagesOf(funcs admissionFuncMap, typ AdmissionType, names []string) ([]pipelineStage, error) {
+	prefix := "/" + strings.ToLower(string(typ)) + "/"
+	stages := make([]pipelineStage, 0, len(names))
+	var errs []error
+	for _, name := range names {
+		handlePath := name
+		if !strings.HasPrefix(handlePath, prefix) {
+			p, err := HandlePath(AdmissionFunc{Type: typ, Path: name})
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			handlePath = p
+		}
+		af, ok := funcs[handlePath]
+		if !ok || af.Type != typ {
+			errs = append(errs, fmt.Errorf("%s admission func [%s] is not registered", strings.ToLower(string(typ)), name))
+			continue
+		}
+		stages = append(stages, pipelineStage{handlePath: handlePath, af: af})
+	}
+	return stages, errors.Join(errs...)
+}
+
+// setupPipelines registers the http handler of the mutating pipelines
+func (d *Dispatcher) setupPipelines(router *route.Router) error {
+	pipelines, err := d.pipelines(d.Config())
+	if err != nil {
+		return err
+	}
+	for handlePath := range pipelines {
+		d.logger.Infof("load admission pipeline: %s", handlePath)
+	}
+	return router.RegisterHandler(route.HandleFunc{
+		Path:   pipelineRoute,
+		Method: http.MethodPost,
+		Func:   d.pipelineHandler,
+	})
+}
+
+// pipelineHandler serves the pipeline named by the request path with the stages of the current config
+func (d *Dispatcher) pipelineHandler(w http.ResponseWriter, r *http.Request) {
+	handlePath := PipelinePath(mux.Vars(r)["name"])
+	d.handler(handlePath, func(ctx context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+		pipelines, _ := d.pipelines(conf.FromContext(ctx))
+		stages, ok := pipelines[handlePath]
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s is not configured", handlePath)
+		}
+		return d.admitPipeline(ctx, handlePath, stages, request)
+	})(w, r)
+}
+
+// admitPipeline calls the stages in order, every stage sees the object patched by the
+// previous stages. The patches of all stages are merged into one response, and the
+// paths touched by more than one stage are reported as warnings.
+func (d *Dispatcher) admitPipeline(ctx context.Context, handlePath string, stages []pipelineStage, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	object := request.Object.Raw
+	merged := make([]json.RawMessage, 0)
+	touched := make(map[string]string)
+	var warnings []string
+	auditAnnotations := make(map[string]string)
+
+	for _, stage := range stages {
+		stageRequest := *request
+		stageRequest.Object.Raw = object
+		resp, err := d.admit(ctx, stage.handlePath, stage.af, &stageRequest)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline stage [%s]: %w", stage.handlePath, err)
+		}
+		if resp == nil {
+			return nil, fmt.Errorf("pipeline stage [%s]: admission func response is empty", stage.handlePath)
+		}
+		if !resp.Allowed {
+			return resp, nil
+		}
+		warnings = append(warnings, resp.Warnings...)
+		for k, v := range resp.AuditAnnotations {
+			auditAnnotations[k] = v
+		}
+		if len(resp.Patch) == 0 {
+			continue
+		}
+
+		patch, err := jsonpatch.DecodePatch(resp.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline stage [%s]: failed to decode patch: %w", stage.handlePath, err)
+		}
+		for _, op := range patch {
+			for _, p := range patchPaths(op) {
+				for prev, prevStage := range touched {
+					if prevStage != stage.handlePath && overwritePath(p, prev) {
+						msg := fmt.Sprintf("pipeline %s: stage %s and %s both touch %s", handlePath, prevStage, stage.handlePath, p)
+						d.logger.Warn(msg)
+						warnings = append(warnings, msg)
+					}
+				}
+				touched[p] = stage.handlePath
+			}
+		}
+
+		object, err = patch.Apply(object)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline stage [%s]: failed to apply patch: %w", stage.handlePath, err)
+		}
+		var ops []json.RawMessage
+		if err = json.Unmarshal(resp.Patch, &ops); err != nil {
+			return nil, fmt.Errorf("pipeline stage [%s]: failed to decode patch: %w", stage.handlePath, err)
+		}
+		merged = append(merged, ops...)
+	}
+
+	resp := Allowed("success")
+	resp.Warnings = dedupe(warnings)
+	if len(auditAnnotations) > 0 {
+		resp.AuditAnnotations = auditAnnotations
+	}
+	if len(merged) > 0 {
+		patch, err := json.Marshal(merged)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal patch: %w", err)
+		}
+		resp.Patch = patch
+		resp.PatchType = JSONPatch()
+	}
+	return resp, nil
+}
+
+// patchPaths returns the paths read or written by the operation
+func patchPaths(op jsonpatch.Operation) []string {
+	var paths []string
+	if p, err := op.Path(); err == nil {
+		paths = append(paths, p)
+	}
+	if from, err := op.From(); err == nil && from != "" {
+		paths = append(paths, from)
+	}
+	return paths
+}
+
+// overwritePath reports whether the json pointer p is equal to or contains the json pointer prev
+func overwritePath(p, prev string) bool {
+	return p == prev || strings.HasPrefix(prev, p+"/")
+}
+
+func dedupe(ss []string) []string {
+	if len(ss) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(ss))
+	out := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package adfunc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+const pipelinePod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","namespace":"default","labels":{"app":"nginx"}},
+"spec":{"containers":[{"name":"nginx","image":"nginx:1.21"}]}}`
+
+// pipelineRegistry returns a registry of the stages "label-a" and "label-b" that set the
+// label "stage", "label-b" copies the label it sees to "seen", and "deny" denies any pod.
+// The calls of the stages are counted by path.
+func pipelineRegistry(calls map[string]*int32) *Registry {
+	registry := NewRegistry()
+	podKinds := []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Pod")}
+	label := func(path, value string) {
+		calls[path] = new(int32)
+		registry.MustRegister(AdmissionFunc{
+			Type:  AdmissionTypeMutating,
+			Path:  path,
+			Kinds: podKinds,
+			Func: Mutate(func(_ context.Context, _ *admissionv1.AdmissionRequest, pod *corev1.Pod) (*corev1.Pod, error) {
+				atomic.AddInt32(calls[path], 1)
+				if seen, ok := pod.Labels["stage"]; ok {
+					pod.Labels["seen"] = seen
+				}
+				pod.Labels["stage"] = value
+				return pod, nil
+			}),
+		})
+	}
+	label("label-a", "a")
+	label("label-b", "b")
+	calls["deny"] = new(int32)
+	registry.MustRegister(AdmissionFunc{
+		Type:  AdmissionTypeMutating,
+		Path:  "deny",
+		Kinds: podKinds,
+		Func: func(_ context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			atomic.AddInt32(calls["deny"], 1)
+			return Denied(http.StatusForbidden, "denied by stage"), nil
+		},
+	})
+	return registry
+}
+
+func TestPipelineStages(t *testing.T) {
+	calls := make(map[string]*int32)
+	cfg := conf.Default()
+	cfg.Pipelines = map[string][]string{"default": {"label-a", "/mutating/label-b"}}
+	handler := newTestHandler(t, pipelineRegistry(calls), cfg)
+
+	resp := reviewResponse(t, post(t, handler, "/mutating/pipeline/default", testReview(t, "Pod", pipelinePod)))
+	if !resp.Allowed {
+		t.Fatalf("pipeline denied the request: %v", resp.Result)
+	}
+	if *calls["label-a"] != 1 || *calls["label-b"] != 1 {
+		t.Errorf("stages are called %d and %d times, want once", *calls["label-a"], *calls["label-b"])
+	}
+
+	ops, err := jsonpatch.DecodePatch(resp.Patch)
+	if err != nil {
+		t.Fatalf("failed to decode merged patch %s: %v", resp.Patch, err)
+	}
+	patched, err := ops.Apply([]byte(pipelinePod))
+	if err != nil {
+		t.Fatalf("failed to apply merged patch %s: %v", resp.Patch, err)
+	}
+	var pod corev1.Pod
+	if err = json.Unmarshal(patched, &pod); err != nil {
+		t.Fatal(err)
+	}
+	// label-b sees the object patched by label-a
+	if pod.Labels["stage"] != "b" || pod.Labels["seen"] != "a" || pod.Labels["app"] != "nginx" {
+		t.Errorf("labels = %v, want stage=b seen=a app=nginx", pod.Labels)
+	}
+
+	var conflict bool
+	for _, w := range resp.Warnings {
+		if strings.Contains(w, "/mutating/label-a and /mutating/label-b both touch /metadata/labels/stage") {
+			conflict = true
+		}
+	}
+	if !conflict {
+		t.Errorf("warnings = %v, want the conflict of /metadata/labels/stage", resp.Warnings)
+	}
+}
+
+func TestPipelineDeny(t *testing.T) {
+	calls := make(map[string]*int32)
+	cfg := conf.Default()
+	cfg.Pipelines = map[string][]string{"default": {"label-a", "deny", "label-b"}}
+	handler := newTestHandler(t, pipelineRegistry(calls), cfg)
+
+	resp := reviewResponse(t, post(t, handler, "/mutating/pipeline/default", testReview(t, "Pod", pipelinePod)))
+	if resp.Allowed {
+		t.Fatal("pipeline allowed the request denied by a stage")
+	}
+	if resp.Result == nil || resp.Result.Message != "denied by stage" {
+		t.Errorf("result = %v, want the denial of the stage", resp.Result)
+	}
+	if len(resp.Patch) != 0 {
+		t.Errorf("denied response has patch %s", resp.Patch)
+	}
+	if *calls["label-a"] != 1 || *calls["deny"] != 1 || *calls["label-b"] != 0 {
+		t.Errorf("stages are called %d, %d and %d times, want 1, 1 and 0",
+			*calls["label-a"], *calls["deny"], *calls["label-b"])
+	}
+}
+
+func TestPipelineUnknown(t *testing.T) {
+	calls := make(map[string]*int32)
+	cfg := conf.Default()
+	cfg.Pipelines = map[string][]string{"default": {"label-a"}}
+	handler := newTestHandler(t, pipelineRegistry(calls), cfg)
+
+	resp := reviewResponse(t, post(t, handler, "/mutating/pipeline/unknown", testReview(t, "Pod", pipelinePod)))
+	if resp.Allowed || resp.Result == nil || !strings.Contains(resp.Result.Message, "is not configured") {
+		t.Errorf("unknown pipeline is served: %v", resp)
+	}
+	if resp.UID != testUID {
+		t.Errorf("uid = %s, want the uid of the request", resp.UID)
+	}
+	if *calls["label-a"] != 0 {
+		t.Errorf("stage of another pipeline is called")
+	}
+
+	// pipelines of unknown stages are rejected by the config check
+	cfg = conf.Default()
+	cfg.Pipelines = map[string][]string{"default": {"label-a", "unknown"}}
+	d := NewDispatcher(pipelineRegistry(calls), cfg, zap.NewNop().Sugar())
+	if err := d.Check(); err == nil || !strings.Contains(err.Error(), "[unknown] is not registered") {
+		t.Errorf("Check() = %v, want the error of the unknown stage", err)
+	}
+}
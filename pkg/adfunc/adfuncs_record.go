@@ -0,0 +1,191 @@
+package adfunc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/redact"
+)
+
+// Record is a line of the JSONL record files written by the dispatcher, the request and
+// response are the AdmissionReviews as they are served with the sensitive values redacted.
+// The record files can be replayed by "goadmission replay" and read by ReadRecords.
+type Record struct {
+	Time     time.Time       `json:"time"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// ReadRecords reads the records of a JSONL record file
+func ReadRecords(r io.Reader) ([]Record, error) {
+	decoder := json.NewDecoder(r)
+	var records []Record
+	for {
+		var record Record
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// recordBuffer is the number of records waiting to be written, the records are
+// dropped when the buffer is full so that a slow disk never delays the responses.
+const recordBuffer = 1024
+
+type recordEntry struct {
+	dir    string
+	record Record
+}
+
+// recorder appends the records to a file per host and day in the record directory,
+// e.g. "goadmission-7d4b9c-x2k8f-20221018.jsonl", so that the replicas can share a volume.
+// The records are written by a background goroutine started with the first record.
+type recorder struct {
+	startOnce sync.Once
+	// mu guards closed, records is closed once the recorder is closed
+	mu      sync.RWMutex
+	closed  bool
+	records chan recordEntry
+	done    chan struct{}
+	dropped atomic.Int64
+
+	host string
+	path string
+	f    *os.File
+}
+
+// enqueue hands the record to the background writer, it is dropped if the buffer is full
+func (r *recorder) enqueue(logger *zap.SugaredLogger, dir string, record Record) {
+	r.startOnce.Do(func() {
+		r.records = make(chan recordEntry, recordBuffer)
+		r.done = make(chan struct{})
+		go r.run(logger)
+	})
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.records <- recordEntry{dir: dir, record: record}:
+	default:
+		if n := r.dropped.Add(1); n == 1 || n%100 == 0 {
+			logger.Warnf("record buffer is full, %d admission reviews are dropped", n)
+		}
+	}
+}
+
+func (r *recorder) run(logger *zap.SugaredLogger) {
+	defer close(r.done)
+	for e := range r.records {
+		if err := r.write(e.dir, e.record); err != nil {
+			logger.Errorf("failed to record admission review of %s: %v", e.record.Path, err)
+		}
+	}
+	if r.f != nil {
+		_ = r.f.Close()
+		r.f, r.path = nil, ""
+	}
+}
+
+// close writes the buffered records and closes the record file
+func (r *recorder) close() {
+	// no writer is started once the recorder is closed
+	r.startOnce.Do(func() {})
+	r.mu.Lock()
+	if r.closed || r.records == nil {
+		r.closed = true
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.records)
+	r.mu.Unlock()
+	<-r.done
+}
+
+func (r *recorder) write(dir string, record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if r.host == "" {
+		if r.host, err = os.Hostname(); err != nil {
+			r.host = "goadmission"
+		}
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", r.host, record.Time.Format("20060102")))
+	if path != r.path {
+		if r.f != nil {
+			_ = r.f.Close()
+			r.f, r.path = nil, ""
+		}
+		if err = os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		if r.f, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+			return err
+		}
+		r.path = path
+	}
+	_, err = r.f.Write(append(line, '\n'))
+	return err
+}
+
+// record hands the admission review of the handle path to the recorder if it is sampled,
+// kind is the kind of the request object. The response is nil if the request failed.
+func (d *Dispatcher) record(cfg *conf.Config, handlePath, kind string, reqBs, respBs []byte) {
+	rc := cfg.Record
+	if rc.Dir == "" || !recordFunc(rc.Funcs, handlePath) || rand.Float64() >= rc.SampleRate {
+		return
+	}
+	// the recorded admission reviews are redacted even if the redaction of logs is disabled
+	redactor := redact.New(cfg.Redact.Paths...)
+	record := Record{
+		Time:    time.Now(),
+		Path:    handlePath,
+		Request: redactor.Review(reqBs),
+	}
+	if rc.Responses && respBs != nil {
+		record.Response = redactor.Response(respBs, kind)
+	}
+	d.recorder.enqueue(d.logger, rc.Dir, record)
+}
+
+// Close writes the admission reviews waiting to be recorded and closes the record
+// file, it is called once the server is shut down. No more reviews are recorded.
+func (d *Dispatcher) Close() {
+	d.recorder.close()
+}
+
+// recordFunc reports whether the admission func of the handle path is recorded
+func recordFunc(funcs []string, handlePath string) bool {
+	if len(funcs) == 0 {
+		return true
+	}
+	for _, p := range funcs {
+		if p == handlePath {
+			return true
+		}
+	}
+	return false
+}
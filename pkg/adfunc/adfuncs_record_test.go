@@ -0,0 +1,152 @@
+package adfunc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/redact"
+	"github.com/mritd/goadmission/pkg/route"
+)
+
+const recordSecret = `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"db","namespace":"default","annotations":{
+"kubectl.kubernetes.io/last-applied-configuration":"{\"apiVersion\":\"v1\",\"kind\":\"Secret\",\"stringData\":{\"password\":\"hunter2\"}}\n"}},
+"data":{"password":"aHVudGVyMg=="},"stringData":{"token":"s3cr3t"}}`
+
+func TestRecordRedacted(t *testing.T) {
+	registry := NewRegistry()
+	registry.MustRegister(AdmissionFunc{
+		Type: AdmissionTypeMutating,
+		Path: "secret",
+		Func: Mutate(func(_ context.Context, _ *admissionv1.AdmissionRequest, secret *corev1.Secret) (*corev1.Secret, error) {
+			secret.Data["api-key"] = []byte("generated-key")
+			return secret, nil
+		}),
+	})
+	cfg := conf.Default()
+	cfg.Record = conf.RecordConfig{Dir: t.TempDir(), SampleRate: 1, Responses: true}
+	logger := zap.NewNop().Sugar()
+	router := route.NewRouter(logger)
+	d := NewDispatcher(registry, cfg, logger)
+	if err := d.Setup(router); err != nil {
+		t.Fatal(err)
+	}
+	handler := router.Handler()
+
+	review, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind":       "AdmissionReview",
+		"request": map[string]interface{}{
+			"uid":       "e911857d-c318-11e8-bbad-025000000001",
+			"kind":      map[string]string{"group": "", "version": "v1", "kind": "Secret"},
+			"resource":  map[string]string{"group": "", "version": "v1", "resource": "secrets"},
+			"name":      "db",
+			"namespace": "default",
+			"operation": "CREATE",
+			"userInfo":  map[string]interface{}{"username": "admin"},
+			"object":    json.RawMessage(recordSecret),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := reviewResponse(t, post(t, handler, "/mutating/secret", review))
+	if !strings.Contains(string(resp.Patch), base64.StdEncoding.EncodeToString([]byte("generated-key"))) {
+		t.Fatalf("patch %s does not add the api key", resp.Patch)
+	}
+	// the records are written in the background until the dispatcher is closed
+	d.Close()
+
+	files, err := filepath.Glob(filepath.Join(cfg.Record.Dir, "*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("record files = %v, %v, want a file", files, err)
+	}
+	bs, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"aHVudGVyMg==", "s3cr3t", "hunter2", "Z2VuZXJhdGVkLWtleQ=="} {
+		if strings.Contains(string(bs), secret) {
+			t.Errorf("%q is left in the record file: %s", secret, bs)
+		}
+	}
+
+	records, err := ReadRecords(strings.NewReader(string(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Path != "/mutating/secret" {
+		t.Fatalf("records = %v, want the record of /mutating/secret", records)
+	}
+	// the redacted values are not base64 encoded, so the object is not decoded as a Secret
+	var recorded struct {
+		Request struct {
+			UID    string `json:"uid"`
+			Object struct {
+				Data map[string]string `json:"data"`
+			} `json:"object"`
+		} `json:"request"`
+	}
+	if err = json.Unmarshal(records[0].Request, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded.Request.UID != "e911857d-c318-11e8-bbad-025000000001" || recorded.Request.Object.Data["password"] != redact.Mask {
+		t.Errorf("recorded request = %s, want the redacted request of the review", records[0].Request)
+	}
+	var response struct {
+		Response admissionv1.AdmissionResponse `json:"response"`
+	}
+	if err = json.Unmarshal(records[0].Response, &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Response.Allowed || len(response.Response.Patch) == 0 {
+		t.Errorf("recorded response = %s, want the allowed response with the redacted patch", records[0].Response)
+	}
+	if !strings.Contains(string(response.Response.Patch), redact.Mask) {
+		t.Errorf("patch of the added secret value is not redacted: %s", response.Response.Patch)
+	}
+}
+
+func TestRecorderDrop(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	dir := t.TempDir()
+	// the writer is not started yet, so the records wait in the buffer of one record
+	r := &recorder{}
+	r.startOnce.Do(func() {
+		r.records = make(chan recordEntry, 1)
+		r.done = make(chan struct{})
+	})
+	for i := 0; i < 3; i++ {
+		r.enqueue(logger, dir, Record{Time: time.Now(), Path: "/mutating/rename", Request: json.RawMessage(`{}`)})
+	}
+	if n := r.dropped.Load(); n != 2 {
+		t.Errorf("dropped = %d, want the records over the buffer dropped", n)
+	}
+
+	go r.run(logger)
+	r.close()
+	r.enqueue(logger, dir, Record{Time: time.Now(), Path: "/mutating/rename", Request: json.RawMessage(`{}`)})
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("record files = %v, %v, want a file", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	records, err := ReadRecords(f)
+	if err != nil || len(records) != 1 {
+		t.Errorf("records = %v, %v, want the buffered record only", records, err)
+	}
+}
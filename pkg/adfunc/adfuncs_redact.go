@@ -0,0 +1,15 @@
+package adfunc
+
+import (
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/redact"
+)
+
+// logRedactor returns the redactor of the logged admission reviews, objects and patches,
+// nothing is redacted if the redaction is disabled by the config.
+func logRedactor(cfg *conf.Config) *redact.Redactor {
+	if cfg.Redact.Disabled {
+		return redact.Nop()
+	}
+	return redact.New(cfg.Redact.Paths...)
+}
@@ -0,0 +1,152 @@
+package adfunc
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrEmptyPath       = errors.New("admission func path is empty")
+	ErrEmptyType       = errors.New("admission func type is empty")
+	ErrEmptyFunc       = errors.New("admission func is nil")
+	ErrRegistryInUse   = errors.New("admission func registry is already in use")
+	ErrUnsupportedType = errors.New("unsupported admission func type")
+)
+
+var handlePathRegexp = regexp.MustCompile(`^(/[a-z0-9]([a-z0-9.-]*[a-z0-9])?)+$`)
+
+// DefaultRegistry is the registry served by default, the builtin admission funcs
+// are registered to it.
+var DefaultRegistry = NewRegistry()
+
+// Registry is a collection of admission funcs indexed by their handle path
+type Registry struct {
+	mu     sync.RWMutex
+	funcs  admissionFuncMap
+	errs   []error
+	sealed bool
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{funcs: make(admissionFuncMap, 10)}
+}
+
+// Register validates the admission func and adds it to the registry.
+// The handle path is prefixed with the admission type, lower-cased and
+// '_' is converted to '-', e.g. "/Image_Rename" => "/mutating/image-rename".
+func (r *Registry) Register(af AdmissionFunc) error {
+	handlePath, err := HandlePath(af)
+	if err != nil {
+		return err
+	}
+	if af.Func == nil {
+		return fmt.Errorf("%w: %s", ErrEmptyFunc, handlePath)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sealed {
+		return fmt.Errorf("%w: %s", ErrRegistryInUse, handlePath)
+	}
+	if _, exist := r.funcs[handlePath]; exist {
+		return fmt.Errorf("admission func [%s], type: %s already registered", handlePath, af.Type)
+	}
+	r.funcs[handlePath] = af
+	return nil
+}
+
+// MustRegister is like Register but panics if the admission func is invalid
+func (r *Registry) MustRegister(af AdmissionFunc) {
+	if err := r.Register(af); err != nil {
+		panic(err)
+	}
+}
+
+// Funcs returns a copy of the registered admission funcs keyed by handle path
+func (r *Registry) Funcs() map[string]AdmissionFunc {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	funcs := make(map[string]AdmissionFunc, len(r.funcs))
+	for p, af := range r.funcs {
+		funcs[p] = af
+	}
+	return funcs
+}
+
+// Paths returns the sorted handle paths of the registered admission funcs
+func (r *Registry) Paths() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	paths := make([]string, 0, len(r.funcs))
+	for p := range r.funcs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Err returns the errors of registrations made during package init,
+// they are reported when a Dispatcher is set up.
+func (r *Registry) Err() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return errors.Join(r.errs...)
+}
+
+// seal checks the registrations and rejects any later Register call
+func (r *Registry) seal() error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sealed = true
+	return nil
+}
+
+// HandlePath returns the http handle path of the admission func
+func HandlePath(af AdmissionFunc) (string, error) {
+	if af.Path == "" {
+		return "", ErrEmptyPath
+	}
+
+	handlePath := strings.ReplaceAll(strings.ToLower(af.Path), "_", "-")
+	if !strings.HasPrefix(handlePath, "/") {
+		handlePath = "/" + handlePath
+	}
+	switch af.Type {
+	case "":
+		return "", fmt.Errorf("%w: %s", ErrEmptyType, af.Path)
+	case AdmissionTypeMutating:
+		handlePath = "/mutating" + handlePath
+	case AdmissionTypeValidating:
+		handlePath = "/validating" + handlePath
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, af.Type)
+	}
+
+	if !handlePathRegexp.MatchString(handlePath) {
+		return "", fmt.Errorf("admission func path is invalid: %s", af.Path)
+	}
+	return handlePath, nil
+}
+
+// Register adds the admission func to the DefaultRegistry
+func Register(af AdmissionFunc) error {
+	return DefaultRegistry.Register(af)
+}
+
+// register is used by the builtin admission funcs in package init, the logger
+// is not ready at that time, so errors are kept and reported by Dispatcher.Setup.
+func register(af AdmissionFunc) {
+	if err := DefaultRegistry.Register(af); err != nil {
+		DefaultRegistry.mu.Lock()
+		DefaultRegistry.errs = append(DefaultRegistry.errs, err)
+		DefaultRegistry.mu.Unlock()
+	}
+}
@@ -0,0 +1,45 @@
+package adfunc
+
+import (
+	"fmt"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Allowed returns a response that allows the request
+func Allowed(msg string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		Result: &metav1.Status{
+			Code:    http.StatusOK,
+			Message: msg,
+		},
+	}
+}
+
+// Denied returns a response that denies the request with the http code
+func Denied(code int32, msg string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Code:    code,
+			Message: msg,
+		},
+	}
+}
+
+// Patched returns a response that allows the request with the json patches
+func Patched(patches []Patch) (*admissionv1.AdmissionResponse, error) {
+	patch, err := jsoniter.Marshal(patches)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal patch: %w", err)
+	}
+	resp := Allowed("success")
+	resp.Patch = patch
+	resp.PatchType = JSONPatch()
+	return resp, nil
+}
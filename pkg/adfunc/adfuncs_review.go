@@ -0,0 +1,99 @@
+package adfunc
+
+import (
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// reviewScheme knows every AdmissionReview version the webhook can serve
+var reviewScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(admissionv1.AddToScheme(reviewScheme))
+	utilruntime.Must(admissionv1beta1.AddToScheme(reviewScheme))
+}
+
+// decodeReview decodes an AdmissionReview of any supported version, v1beta1 reviews
+// are converted to v1 so that admission funcs only need to handle one shape.
+// The returned GroupVersionKind is the version the apiserver sent.
+func decodeReview(decoder runtime.Decoder, bs []byte) (*admissionv1.AdmissionReview, *schema.GroupVersionKind, error) {
+	obj, gvk, err := decoder.Decode(bs, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		return review, gvk, nil
+	case *admissionv1beta1.AdmissionReview:
+		return &admissionv1.AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Request:  convertRequestFromV1beta1(review.Request),
+		}, gvk, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported admission review type: %s", gvk)
+	}
+}
+
+// encodeReview builds the response AdmissionReview in the same version as the request
+func encodeReview(gvk *schema.GroupVersionKind, resp *admissionv1.AdmissionResponse) (runtime.Object, error) {
+	switch gvk.GroupVersion() {
+	case admissionv1.SchemeGroupVersion:
+		review := &admissionv1.AdmissionReview{Response: resp}
+		review.SetGroupVersionKind(*gvk)
+		return review, nil
+	case admissionv1beta1.SchemeGroupVersion:
+		review := &admissionv1beta1.AdmissionReview{Response: convertResponseToV1beta1(resp)}
+		review.SetGroupVersionKind(*gvk)
+		return review, nil
+	default:
+		return nil, fmt.Errorf("unsupported admission review version: %s", gvk.GroupVersion())
+	}
+}
+
+func convertRequestFromV1beta1(r *admissionv1beta1.AdmissionRequest) *admissionv1.AdmissionRequest {
+	if r == nil {
+		return nil
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:                r.UID,
+		Kind:               r.Kind,
+		Resource:           r.Resource,
+		SubResource:        r.SubResource,
+		RequestKind:        r.RequestKind,
+		RequestResource:    r.RequestResource,
+		RequestSubResource: r.RequestSubResource,
+		Name:               r.Name,
+		Namespace:          r.Namespace,
+		Operation:          admissionv1.Operation(r.Operation),
+		UserInfo:           r.UserInfo,
+		Object:             r.Object,
+		OldObject:          r.OldObject,
+		DryRun:             r.DryRun,
+		Options:            r.Options,
+	}
+}
+
+func convertResponseToV1beta1(r *admissionv1.AdmissionResponse) *admissionv1beta1.AdmissionResponse {
+	if r == nil {
+		return nil
+	}
+	resp := &admissionv1beta1.AdmissionResponse{
+		UID:              r.UID,
+		Allowed:          r.Allowed,
+		Result:           r.Result,
+		Patch:            r.Patch,
+		AuditAnnotations: r.AuditAnnotations,
+		Warnings:         r.Warnings,
+	}
+	if r.PatchType != nil {
+		pt := admissionv1beta1.PatchType(*r.PatchType)
+		resp.PatchType = &pt
+	}
+	return resp
+}
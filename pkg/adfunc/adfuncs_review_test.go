@@ -0,0 +1,108 @@
+package adfunc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/route"
+)
+
+// newTestHandler returns the http handler of a dispatcher serving the registry
+func newTestHandler(t *testing.T, registry *Registry, cfg *conf.Config) http.Handler {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	router := route.NewRouter(logger)
+	if err := NewDispatcher(registry, cfg, logger).Setup(router); err != nil {
+		t.Fatalf("failed to setup dispatcher: %v", err)
+	}
+	return router.Handler()
+}
+
+// post posts the body to the handle path and returns the decoded response review
+func post(t *testing.T, handler http.Handler, path string, body []byte) map[string]interface{} {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST %s: http %d: %s", path, rec.Code, rec.Body.String())
+	}
+	var review map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Fatalf("POST %s: failed to decode response: %v", path, err)
+	}
+	return review
+}
+
+func TestHandlerReviewVersions(t *testing.T) {
+	handler := newTestHandler(t, DefaultRegistry, conf.Default())
+
+	tests := []struct {
+		file       string
+		apiVersion string
+		uid        string
+	}{
+		{"testdata/review-v1.json", "admission.k8s.io/v1", "705ab4f5-6393-11e8-b7cc-42010a800002"},
+		{"testdata/review-v1beta1.json", "admission.k8s.io/v1beta1", "8b6b1a1c-6393-11e8-b7cc-42010a800002"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.apiVersion, func(t *testing.T) {
+			body, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			review := post(t, handler, "/mutating/rename", body)
+
+			if review["apiVersion"] != tt.apiVersion || review["kind"] != "AdmissionReview" {
+				t.Errorf("got %v %v, want %s AdmissionReview", review["apiVersion"], review["kind"], tt.apiVersion)
+			}
+			resp, ok := review["response"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("response is missing: %v", review)
+			}
+			if resp["uid"] != tt.uid {
+				t.Errorf("uid = %v, want %s", resp["uid"], tt.uid)
+			}
+			if resp["allowed"] != true {
+				t.Errorf("allowed = %v, want true", resp["allowed"])
+			}
+			if resp["patchType"] != "JSONPatch" {
+				t.Errorf("patchType = %v, want JSONPatch", resp["patchType"])
+			}
+			if patch, _ := resp["patch"].(string); patch == "" {
+				t.Errorf("patch is empty")
+			}
+
+			var typed struct {
+				Response struct {
+					Patch []byte `json:"patch"`
+				} `json:"response"`
+			}
+			bs, _ := json.Marshal(review)
+			if err = json.Unmarshal(bs, &typed); err != nil {
+				t.Fatal(err)
+			}
+			var ops []Patch
+			if err = json.Unmarshal(typed.Response.Patch, &ops); err != nil {
+				t.Fatalf("failed to decode patch: %v", err)
+			}
+			var replaced bool
+			for _, op := range ops {
+				if op.Path == "/spec/containers/0/image" && op.Value == "gcrxio/k8s.gcr.io_pause:3.6" {
+					replaced = true
+				}
+			}
+			if !replaced {
+				t.Errorf("patch does not rename the image: %s", typed.Response.Patch)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package adfunc
+
+import (
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// inScope reports whether the request matches the kinds, operations and
+// subresources declared by the admission func
+func (af AdmissionFunc) inScope(request *admissionv1.AdmissionRequest) bool {
+	return af.matchKind(request) && af.matchOperation(request.Operation) && af.matchSubResource(request.SubResource)
+}
+
+func (af AdmissionFunc) matchKind(request *admissionv1.AdmissionRequest) bool {
+	if len(af.Kinds) == 0 {
+		return true
+	}
+	gvk := schema.GroupVersionKind{
+		Group:   request.Kind.Group,
+		Version: request.Kind.Version,
+		Kind:    request.Kind.Kind,
+	}
+	for _, k := range af.Kinds {
+		if (k.Group == "*" || k.Group == gvk.Group) &&
+			(k.Version == "*" || k.Version == gvk.Version) &&
+			(k.Kind == "*" || k.Kind == gvk.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func (af AdmissionFunc) matchOperation(op admissionv1.Operation) bool {
+	if len(af.Operations) == 0 {
+		return true
+	}
+	for _, o := range af.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (af AdmissionFunc) matchSubResource(subResource string) bool {
+	if len(af.SubResources) == 0 {
+		return subResource == ""
+	}
+	for _, s := range af.SubResources {
+		if s == "*" || s == subResource {
+			return true
+		}
+	}
+	return false
+}
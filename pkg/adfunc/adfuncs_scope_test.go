@@ -0,0 +1,81 @@
+package adfunc
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+func TestInScope(t *testing.T) {
+	pod := corev1.SchemeGroupVersion.WithKind("Pod")
+	deploy := appsv1.SchemeGroupVersion.WithKind("Deployment")
+	request := func(gvk schema.GroupVersionKind, op admissionv1.Operation, subResource string) *admissionv1.AdmissionRequest {
+		return &admissionv1.AdmissionRequest{
+			Kind:        metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+			Operation:   op,
+			SubResource: subResource,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		af      AdmissionFunc
+		request *admissionv1.AdmissionRequest
+		want    bool
+	}{
+		{"all kinds", AdmissionFunc{}, request(deploy, admissionv1.Create, ""), true},
+		{"kind", AdmissionFunc{Kinds: []schema.GroupVersionKind{pod}}, request(pod, admissionv1.Create, ""), true},
+		{"other kind", AdmissionFunc{Kinds: []schema.GroupVersionKind{pod}}, request(deploy, admissionv1.Create, ""), false},
+		{"other group", AdmissionFunc{Kinds: []schema.GroupVersionKind{{Group: "extensions", Version: "v1", Kind: "Deployment"}}}, request(deploy, admissionv1.Create, ""), false},
+		{"any group", AdmissionFunc{Kinds: []schema.GroupVersionKind{{Group: "*", Version: "v1", Kind: "Deployment"}}}, request(deploy, admissionv1.Create, ""), true},
+		{"any version", AdmissionFunc{Kinds: []schema.GroupVersionKind{{Group: "apps", Version: "*", Kind: "Deployment"}}}, request(deploy, admissionv1.Create, ""), true},
+		{"any kind", AdmissionFunc{Kinds: []schema.GroupVersionKind{{Group: "", Version: "v1", Kind: "*"}}}, request(pod, admissionv1.Create, ""), true},
+		{"any kind of other group", AdmissionFunc{Kinds: []schema.GroupVersionKind{{Group: "", Version: "v1", Kind: "*"}}}, request(deploy, admissionv1.Create, ""), false},
+		{"operation", AdmissionFunc{Operations: []admissionv1.Operation{admissionv1.Create, admissionv1.Update}}, request(pod, admissionv1.Update, ""), true},
+		{"other operation", AdmissionFunc{Operations: []admissionv1.Operation{admissionv1.Create}}, request(pod, admissionv1.Delete, ""), false},
+		{"main resource only", AdmissionFunc{}, request(pod, admissionv1.Create, "status"), false},
+		{"subresource", AdmissionFunc{SubResources: []string{"", "status"}}, request(pod, admissionv1.Update, "status"), true},
+		{"main resource of subresources", AdmissionFunc{SubResources: []string{"", "status"}}, request(pod, admissionv1.Update, ""), true},
+		{"other subresource", AdmissionFunc{SubResources: []string{"status"}}, request(pod, admissionv1.Create, "exec"), false},
+		{"any subresource", AdmissionFunc{SubResources: []string{"*"}}, request(pod, admissionv1.Create, "exec"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.af.inScope(tt.request); got != tt.want {
+				t.Errorf("inScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutOfScopeAllowed(t *testing.T) {
+	var called bool
+	registry := NewRegistry()
+	registry.MustRegister(AdmissionFunc{
+		Type:  AdmissionTypeValidating,
+		Path:  "deny-deployments",
+		Kinds: []schema.GroupVersionKind{appsv1.SchemeGroupVersion.WithKind("Deployment")},
+		Func: func(_ context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			called = true
+			return Denied(http.StatusForbidden, "deployments are denied"), nil
+		},
+	})
+	handler := newTestHandler(t, registry, conf.Default())
+
+	pod := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx"},"spec":{"containers":[{"name":"nginx","image":"nginx"}]}}`
+	resp := reviewResponse(t, post(t, handler, "/validating/deny-deployments", testReview(t, "Pod", pod)))
+	if !resp.Allowed || len(resp.Patch) != 0 {
+		t.Errorf("response = %v, want the out of scope request allowed unchanged", resp)
+	}
+	if called {
+		t.Error("admission func is called with the out of scope request")
+	}
+}
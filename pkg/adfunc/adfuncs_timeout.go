@@ -0,0 +1,187 @@
+package adfunc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mritd/goadmission/pkg/conf"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// timeoutMargin is reserved from the request timeout, so that the fallback
+// response can reach the apiserver before it gives up the request.
+const timeoutMargin = 200 * time.Millisecond
+
+// Fallback is the decision returned instead of the admission func result
+type Fallback struct {
+	Allowed bool
+	Message string
+}
+
+// DefaultTimeoutFallback allows the request when the admission func times out
+var DefaultTimeoutFallback = Fallback{Allowed: true, Message: "admission func timed out, allowed by fallback"}
+
+// ParseFallback parses the fallback definition "allow", "deny", "allow:<message>" or "deny:<message>"
+func ParseFallback(s string) (Fallback, error) {
+	ss := strings.SplitN(s, ":", 2)
+	var fb Fallback
+	switch strings.TrimSpace(ss[0]) {
+	case "allow":
+		fb.Allowed = true
+	case "deny":
+		fb.Allowed = false
+	default:
+		return fb, fmt.Errorf("unsupported fallback: %s", s)
+	}
+	if len(ss) == 2 {
+		fb.Message = strings.TrimSpace(ss[1])
+	}
+	return fb, nil
+}
+
+// response returns the admission response of the fallback, code is used if the request is denied
+func (fb Fallback) response(code int32, defaultMsg string) *admissionv1.AdmissionResponse {
+	msg := fb.Message
+	if msg == "" {
+		msg = defaultMsg
+	}
+	if fb.Allowed {
+		return Allowed(msg)
+	}
+	return Denied(code, msg)
+}
+
+// requestTimeout returns the timeout the apiserver waits for the webhook, the apiserver
+// sends it as the "timeout" query parameter, e.g. "/mutating/rename?timeout=10s".
+func requestTimeout(r *http.Request) (time.Duration, bool) {
+	t, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+	if err != nil || t <= 0 {
+		return 0, false
+	}
+	if t > 2*timeoutMargin {
+		t -= timeoutMargin
+	}
+	return t, true
+}
+
+// timeout returns the configured timeout of the admission func, 0 means no timeout
+func (d *Dispatcher) timeout(cfg *conf.Config, handlePath string, af AdmissionFunc) time.Duration {
+	if t, err := time.ParseDuration(cfg.Func(handlePath).Timeout); err == nil {
+		return t
+	}
+	return af.Timeout
+}
+
+// timeoutFallback returns the configured timeout fallback of the admission func
+func (d *Dispatcher) timeoutFallback(cfg *conf.Config, handlePath string, af AdmissionFunc) Fallback {
+	if fb, err := ParseFallback(cfg.Func(handlePath).TimeoutFallback); err == nil {
+		return fb
+	}
+	if af.TimeoutFallback != nil {
+		return *af.TimeoutFallback
+	}
+	return DefaultTimeoutFallback
+}
+
+type funcResult struct {
+	resp *admissionv1.AdmissionResponse
+	err  error
+	// panicked is the value recovered from the admission func
+	panicked interface{}
+	stack    []byte
+}
+
+// result returns the response of the admission func, or the fallback response if it panicked
+func (d *Dispatcher) result(cfg *conf.Config, handlePath string, af AdmissionFunc, request *admissionv1.AdmissionRequest, r funcResult) (*admissionv1.AdmissionResponse, error) {
+	if r.panicked != nil {
+		return d.recovered(cfg, handlePath, af, request, r), nil
+	}
+	return r.resp, r.err
+}
+
+// callWithDeadline calls the admission func and waits until ctx is done, the fallback
+// response is returned if the admission func panics or does not return before the deadline.
+// The admission func is not stopped when ctx is done, it keeps running until it returns,
+// so admission funcs should return once ctx is done. Such funcs are counted by Overdue.
+func (d *Dispatcher) callWithDeadline(ctx context.Context, cfg *conf.Config, handlePath string, af AdmissionFunc, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	if t := d.timeout(cfg, handlePath, af); t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return d.result(cfg, handlePath, af, request, call(ctx, af, request))
+	}
+
+	start := time.Now()
+	ch := make(chan funcResult, 1)
+	// state is running until the admission func returns or the caller gives up waiting
+	var state atomic.Int32
+	go func() {
+		r := call(ctx, af, request)
+		if !state.CompareAndSwap(funcRunning, funcReturned) {
+			d.overdueDone(handlePath, af, time.Since(start))
+		}
+		ch <- r
+	}()
+
+	select {
+	case r := <-ch:
+		// the result is discarded if the admission func returned because ctx is done
+		if ctx.Err() == nil || r.panicked != nil {
+			return d.result(cfg, handlePath, af, request, r)
+		}
+	case <-ctx.Done():
+		if state.CompareAndSwap(funcRunning, funcAbandoned) {
+			d.overdueStart(handlePath)
+		}
+	}
+	reason := "timed out"
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = "canceled"
+	}
+	fb := d.timeoutFallback(cfg, handlePath, af)
+	d.logger.Warnf("[route.%s] %s: %s %s/%s %s after %s, fallback allowed: %t",
+		af.Type, handlePath, request.Kind.Kind, request.Namespace, request.Name, reason, time.Since(start), fb.Allowed)
+	return fb.response(http.StatusGatewayTimeout, fmt.Sprintf("admission func %s %s", handlePath, reason)), nil
+}
+
+const (
+	funcRunning int32 = iota
+	funcReturned
+	funcAbandoned
+)
+
+// overdueStart counts the admission func that is still running after its response is sent
+func (d *Dispatcher) overdueStart(handlePath string) {
+	d.overdueMu.Lock()
+	defer d.overdueMu.Unlock()
+	d.overdue[handlePath]++
+}
+
+// overdueDone logs the overdue admission func that returned at last
+func (d *Dispatcher) overdueDone(handlePath string, af AdmissionFunc, elapsed time.Duration) {
+	d.overdueMu.Lock()
+	d.overdue[handlePath]--
+	d.overdueMu.Unlock()
+	d.logger.Warnf("[route.%s] %s: admission func returned %s after it was called, it should return once ctx is done", af.Type, handlePath, elapsed)
+}
+
+// Overdue returns the number of admission funcs still running after their deadline keyed by handle path
+func (d *Dispatcher) Overdue() map[string]int64 {
+	d.overdueMu.Lock()
+	defer d.overdueMu.Unlock()
+	overdue := make(map[string]int64, len(d.overdue))
+	for p, c := range d.overdue {
+		if c > 0 {
+			overdue[p] = c
+		}
+	}
+	return overdue
+}
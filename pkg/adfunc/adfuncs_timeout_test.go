@@ -0,0 +1,183 @@
+package adfunc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/route"
+)
+
+const slowPod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","namespace":"default"},"spec":{"containers":[]}}`
+
+// slowRegistry returns a registry of "/validating/slow" that waits until ctx is done, the
+// remaining time of ctx is sent to deadlines when it is called.
+func slowRegistry(timeout time.Duration, deadlines chan<- time.Duration) *Registry {
+	registry := NewRegistry()
+	registry.MustRegister(AdmissionFunc{
+		Type:    AdmissionTypeValidating,
+		Path:    "slow",
+		Timeout: timeout,
+		Func: func(ctx context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			if deadline, ok := ctx.Deadline(); ok && deadlines != nil {
+				deadlines <- time.Until(deadline)
+			}
+			<-ctx.Done()
+			return Denied(http.StatusForbidden, "the result after the deadline is discarded"), nil
+		},
+	})
+	return registry
+}
+
+func TestTimeoutFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		allowed  bool
+		code     int32
+		message  string
+	}{
+		{"default", "", true, http.StatusOK, DefaultTimeoutFallback.Message},
+		{"allow", "allow", true, http.StatusOK, "admission func /validating/slow timed out"},
+		{"deny", "deny:deploy check timed out", false, http.StatusGatewayTimeout, "deploy check timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := conf.Default()
+			cfg.Funcs["/validating/slow"] = conf.FuncConfig{TimeoutFallback: tt.fallback}
+			handler := newTestHandler(t, slowRegistry(50*time.Millisecond, nil), cfg)
+
+			start := time.Now()
+			resp := reviewResponse(t, post(t, handler, "/validating/slow", testReview(t, "Pod", slowPod)))
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("response is sent after %s, want it at the timeout of the admission func", elapsed)
+			}
+			if resp.Allowed != tt.allowed || resp.Result == nil || resp.Result.Code != tt.code || resp.Result.Message != tt.message {
+				t.Errorf("response = %v, want allowed %v with %d %q", resp, tt.allowed, tt.code, tt.message)
+			}
+			if resp.UID != testUID {
+				t.Errorf("uid = %s, want the uid of the request", resp.UID)
+			}
+		})
+	}
+}
+
+func TestTimeoutMargin(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		query   string
+		want    time.Duration
+	}{
+		{"request timeout", 0, "?timeout=1s", time.Second - timeoutMargin},
+		{"short request timeout", 0, "?timeout=300ms", 300 * time.Millisecond},
+		{"shorter func timeout", 100 * time.Millisecond, "?timeout=1s", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadlines := make(chan time.Duration, 1)
+			handler := newTestHandler(t, slowRegistry(tt.timeout, deadlines), conf.Default())
+			resp := reviewResponse(t, post(t, handler, "/validating/slow"+tt.query, testReview(t, "Pod", slowPod)))
+			if !resp.Allowed {
+				t.Errorf("response = %v, want the default fallback", resp)
+			}
+			if remaining := <-deadlines; remaining > tt.want || remaining < tt.want-100*time.Millisecond {
+				t.Errorf("deadline of the admission func is in %s, want %s", remaining, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutCanceled(t *testing.T) {
+	started := make(chan time.Duration, 1)
+	cfg := conf.Default()
+	cfg.Funcs["/validating/slow"] = conf.FuncConfig{TimeoutFallback: "deny"}
+	handler := newTestHandler(t, slowRegistry(time.Minute, started), cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/validating/slow", bytes.NewReader(testReview(t, "Pod", slowPod))).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	go func() {
+		<-started
+		cancel()
+	}()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("http %d: %s", rec.Code, rec.Body.String())
+	}
+	var review map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Fatal(err)
+	}
+	resp := reviewResponse(t, review)
+	if resp.Allowed || resp.UID != testUID || resp.Result == nil || resp.Result.Message != "admission func /validating/slow canceled" {
+		t.Errorf("response = %v, want the timeout fallback of the canceled request", resp)
+	}
+}
+
+func TestTimeoutOverdue(t *testing.T) {
+	release := make(chan struct{})
+	registry := NewRegistry()
+	registry.MustRegister(AdmissionFunc{
+		Type:    AdmissionTypeValidating,
+		Path:    "stubborn",
+		Timeout: 50 * time.Millisecond,
+		Func: func(_ context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			<-release
+			return Allowed("success"), nil
+		},
+	})
+	logger := zap.NewNop().Sugar()
+	router := route.NewRouter(logger)
+	d := NewDispatcher(registry, conf.Default(), logger)
+	if err := d.Setup(router); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := reviewResponse(t, post(t, router.Handler(), "/validating/stubborn", testReview(t, "Pod", slowPod)))
+	if !resp.Allowed || resp.Result.Message != DefaultTimeoutFallback.Message {
+		t.Errorf("response = %v, want the default fallback", resp)
+	}
+	if overdue := d.Overdue()["/validating/stubborn"]; overdue != 1 {
+		t.Errorf("overdue = %d, want the admission func ignoring ctx counted", overdue)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.Overdue()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("overdue = %v, want none once the admission func returned", d.Overdue())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseFallback(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Fallback
+	}{
+		{"allow", Fallback{Allowed: true}},
+		{"deny", Fallback{Allowed: false}},
+		{"deny: too slow ", Fallback{Allowed: false, Message: "too slow"}},
+		{"allow:skipped", Fallback{Allowed: true, Message: "skipped"}},
+	}
+	for _, tt := range tests {
+		if fb, err := ParseFallback(tt.s); err != nil || fb != tt.want {
+			t.Errorf("ParseFallback(%q) = %v, %v, want %v", tt.s, fb, err, tt.want)
+		}
+	}
+	if _, err := ParseFallback("ignore"); err == nil {
+		t.Error("unsupported fallback is parsed")
+	}
+}
@@ -0,0 +1,98 @@
+package adfunc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+// TypedFunc is an admission control handler that receives the decoded objects of
+// the request. obj is nil if the request has no Object (e.g. DELETE), and oldObj
+// is nil if the request has no OldObject (e.g. CREATE).
+type TypedFunc[T runtime.Object] func(ctx context.Context, request *admissionv1.AdmissionRequest, obj, oldObj T) (*admissionv1.AdmissionResponse, error)
+
+// Typed converts a TypedFunc to a Func, the Object and OldObject of the request are
+// decoded into T and the request is denied with 400 if they can not be decoded.
+// The kinds of T should be declared in AdmissionFunc.Kinds, so that other kinds
+// never reach the TypedFunc.
+func Typed[T any, PT interface {
+	*T
+	runtime.Object
+}](fn TypedFunc[PT]) Func {
+	return func(ctx context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+		obj, err := decodeObject[T, PT](request.Object)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to unmarshal %s object: %v", request.Kind.Kind, err)
+			Logger(ctx).Error(errMsg)
+			return Denied(http.StatusBadRequest, errMsg), nil
+		}
+		oldObj, err := decodeObject[T, PT](request.OldObject)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to unmarshal %s old object: %v", request.Kind.Kind, err)
+			Logger(ctx).Error(errMsg)
+			return Denied(http.StatusBadRequest, errMsg), nil
+		}
+		return fn(ctx, request, obj, oldObj)
+	}
+}
+
+func decodeObject[T any, PT interface {
+	*T
+	runtime.Object
+}](raw runtime.RawExtension) (PT, error) {
+	if len(raw.Raw) == 0 {
+		return nil, nil
+	}
+	obj := PT(new(T))
+	if err := jsoniter.Unmarshal(raw.Raw, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// MutateFunc is a mutating admission control handler that changes the given copy of
+// the decoded object and returns it, returning nil means the object is not changed.
+type MutateFunc[T runtime.Object] func(ctx context.Context, request *admissionv1.AdmissionRequest, obj T) (T, error)
+
+// Mutate converts a MutateFunc to a Func, the json patch of the response is computed
+// by diffing the returned object against the raw object of the request. Requests
+// without Object (e.g. DELETE) are allowed without calling the MutateFunc.
+func Mutate[T any, PT interface {
+	*T
+	runtime.Object
+}](fn MutateFunc[PT]) Func {
+	return Typed[T, PT](func(ctx context.Context, request *admissionv1.AdmissionRequest, obj, _ PT) (*admissionv1.AdmissionResponse, error) {
+		if obj == nil {
+			return Allowed("success"), nil
+		}
+		mutated, err := fn(ctx, request, obj.DeepCopyObject().(PT))
+		if err != nil {
+			return nil, err
+		}
+		if mutated == nil {
+			return Allowed("success"), nil
+		}
+
+		patches, err := DiffPatch(request.Object.Raw, obj, mutated)
+		if err != nil {
+			return nil, err
+		}
+		if len(patches) == 0 {
+			return Allowed("success"), nil
+		}
+		resp, err := Patched(patches)
+		if err != nil {
+			return nil, err
+		}
+		patch := logRedactor(conf.FromContext(ctx)).Patch(resp.Patch, request.Kind.Kind)
+		Logger(ctx).Infof("%s %s/%s patches: %s", request.Kind.Kind, request.Namespace, request.Name, patch)
+		return resp, nil
+	})
+}
@@ -0,0 +1,91 @@
+package adfunc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+const testUID = "e911857d-c318-11e8-bbad-025000000001"
+
+// testReview returns a v1 AdmissionReview creating the object of the core kind
+func testReview(t *testing.T, kind, object string) []byte {
+	t.Helper()
+	review := map[string]interface{}{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind":       "AdmissionReview",
+		"request": map[string]interface{}{
+			"uid":       testUID,
+			"kind":      map[string]string{"group": "", "version": "v1", "kind": kind},
+			"resource":  map[string]string{"group": "", "version": "v1", "resource": "pods"},
+			"name":      "nginx",
+			"namespace": "default",
+			"operation": "CREATE",
+			"userInfo":  map[string]interface{}{"username": "admin"},
+			"object":    json.RawMessage(object),
+		},
+	}
+	bs, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+// reviewResponse decodes the response of the response review
+func reviewResponse(t *testing.T, review map[string]interface{}) *admissionv1.AdmissionResponse {
+	t.Helper()
+	bs, err := json.Marshal(review["response"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp admissionv1.AdmissionResponse
+	if err = json.Unmarshal(bs, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
+
+func TestTypedDecodeFailure(t *testing.T) {
+	var called bool
+	registry := NewRegistry()
+	registry.MustRegister(AdmissionFunc{
+		Type:  AdmissionTypeValidating,
+		Path:  "typed",
+		Kinds: []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Pod")},
+		Func: Typed(func(_ context.Context, _ *admissionv1.AdmissionRequest, _, _ *corev1.Pod) (*admissionv1.AdmissionResponse, error) {
+			called = true
+			return Allowed("success"), nil
+		}),
+	})
+	handler := newTestHandler(t, registry, conf.Default())
+
+	tests := []struct {
+		name   string
+		object string
+	}{
+		{"invalid spec", `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx"},"spec":"nginx"}`},
+		{"invalid containers", `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx"},"spec":{"containers":{"name":"nginx"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := reviewResponse(t, post(t, handler, "/validating/typed", testReview(t, "Pod", tt.object)))
+			if resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusBadRequest {
+				t.Errorf("response = %v, want the 400 denial", resp)
+			}
+			if resp.UID != testUID {
+				t.Errorf("uid = %s, want the uid of the request", resp.UID)
+			}
+			if called {
+				t.Error("typed func is called with the object failed to decode")
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package adfunc
+
+import (
+	"math"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// DefaultTimeoutSeconds is the webhook timeout of the admission funcs without Timeout,
+	// it is the default of the apiserver.
+	DefaultTimeoutSeconds int32 = 10
+	// MaxTimeoutSeconds is the max webhook timeout the apiserver accepts
+	MaxTimeoutSeconds int32 = 30
+)
+
+// Rules returns the webhook rules that send the requests in the scope of the admission func,
+// the resources are guessed from the kinds, e.g. "Deployment" => "deployments".
+func (af AdmissionFunc) Rules() []admissionregistrationv1.RuleWithOperations {
+	operations := make([]admissionregistrationv1.OperationType, 0, len(af.Operations))
+	for _, op := range af.Operations {
+		operations = append(operations, admissionregistrationv1.OperationType(op))
+	}
+	if len(operations) == 0 {
+		operations = []admissionregistrationv1.OperationType{admissionregistrationv1.OperationAll}
+	}
+
+	kinds := af.Kinds
+	if len(kinds) == 0 {
+		kinds = []schema.GroupVersionKind{{Group: "*", Version: "*", Kind: "*"}}
+	}
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(kinds))
+	for _, gvk := range kinds {
+		resource := "*"
+		if gvk.Kind != "*" {
+			plural, _ := meta.UnsafeGuessKindToResource(gvk)
+			resource = plural.Resource
+		}
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{gvk.Group},
+				APIVersions: []string{gvk.Version},
+				Resources:   af.resources(resource),
+			},
+		})
+	}
+	return rules
+}
+
+// resources returns the resource and its subresources in the scope of the admission func
+func (af AdmissionFunc) resources(resource string) []string {
+	if len(af.SubResources) == 0 {
+		return []string{resource}
+	}
+	resources := make([]string, 0, len(af.SubResources))
+	for _, sub := range af.SubResources {
+		switch sub {
+		case "":
+			resources = append(resources, resource)
+		case "*":
+			resources = append(resources, resource, resource+"/*")
+		default:
+			resources = append(resources, resource+"/"+sub)
+		}
+	}
+	return dedupe(resources)
+}
+
+// SideEffectClass returns the side effects of the admission func, it is None if SideEffects is empty
+func (af AdmissionFunc) SideEffectClass() admissionregistrationv1.SideEffectClass {
+	if af.SideEffects == "" {
+		return admissionregistrationv1.SideEffectClassNone
+	}
+	return af.SideEffects
+}
+
+// TimeoutSeconds returns the webhook timeout of the admission func, it leaves time for the
+// timeout fallback of the admission func to reach the apiserver.
+func (af AdmissionFunc) TimeoutSeconds() int32 {
+	if af.Timeout <= 0 {
+		return DefaultTimeoutSeconds
+	}
+	s := int32(math.Ceil((af.Timeout + 2*timeoutMargin).Seconds()))
+	if s > MaxTimeoutSeconds {
+		return MaxTimeoutSeconds
+	}
+	return s
+}
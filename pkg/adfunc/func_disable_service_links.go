@@ -1,107 +1,42 @@
 package adfunc
 
 import (
-	"fmt"
-	"net/http"
-	"time"
+	"context"
 
 	"github.com/mritd/goadmission/pkg/conf"
 
 	appsv1 "k8s.io/api/apps/v1"
 
-	jsoniter "github.com/json-iterator/go"
-
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
 	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func init() {
 	register(AdmissionFunc{
-		Type: AdmissionTypeMutating,
-		Path: "/disable-service-links",
-		Func: disableServiceLinks,
+		Type:       AdmissionTypeMutating,
+		Path:       "/disable-service-links",
+		Kinds:      []schema.GroupVersionKind{appsv1.SchemeGroupVersion.WithKind("Deployment")},
+		Operations: []admissionv1.Operation{admissionv1.Create, admissionv1.Update},
+		Func:       Mutate(disableServiceLinks),
 	})
 }
 
+// disableServiceLinksAnnotation marks the mutated Deployment, the key is fixed so that
+// the same Deployment is always patched the same way
+const disableServiceLinksAnnotation = "disable-service-links-mutatingwebhook.mritd.com"
+
 // disableServiceLinks auto set enableServiceLinks of the target Deployment to false
 // to prevent k8s environment variable injection
-func disableServiceLinks(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
-	switch request.Kind.Kind {
-	case "Deployment":
-		var deploy appsv1.Deployment
-		err := jsoniter.Unmarshal(request.Object.Raw, &deploy)
-		if err != nil {
-			errMsg := fmt.Sprintf("[route.Mutating] /disable-service-links: failed to unmarshal object: %v", err)
-			logger.Error(errMsg)
-			return &admissionv1.AdmissionResponse{
-				Allowed: false,
-				Result: &metav1.Status{
-					Code:    http.StatusBadRequest,
-					Message: errMsg,
-				},
-			}, nil
-		}
-
-		for label := range deploy.Labels {
-			if label == conf.ForceEnableServiceLinksLabel {
-				return &admissionv1.AdmissionResponse{
-					Allowed: true,
-					Result: &metav1.Status{
-						Code:    http.StatusOK,
-						Message: "success",
-					},
-				}, nil
-			}
-		}
-
-		patches := []Patch{
-			{
-				Option: PatchOptionAdd,
-				Path:   "/metadata/annotations",
-				Value: map[string]string{
-					fmt.Sprintf("disable-service-links-mutatingwebhook-%d.mritd.com", time.Now().Unix()): "true",
-				},
-			},
-			{
-				Option: PatchOptionReplace,
-				Path:   "/spec/template/spec/enableServiceLinks",
-				Value:  false,
-			},
-		}
-
-		patch, err := jsoniter.Marshal(patches)
-		if err != nil {
-			errMsg := fmt.Sprintf("[route.Mutating] /disable-service-links: failed to marshal patch: %v", err)
-			logger.Error(errMsg)
-			return &admissionv1.AdmissionResponse{
-				Allowed: false,
-				Result: &metav1.Status{
-					Code:    http.StatusInternalServerError,
-					Message: errMsg,
-				},
-			}, nil
-		}
+func disableServiceLinks(ctx context.Context, _ *admissionv1.AdmissionRequest, deploy *appsv1.Deployment) (*appsv1.Deployment, error) {
+	if _, ok := deploy.Labels[conf.FromContext(ctx).DisableServiceLinks.ForceEnableLabel]; ok {
+		return nil, nil
+	}
 
-		logger.Infof("[route.Mutating] /disable-service-links: patches: %s", string(patch))
-		return &admissionv1.AdmissionResponse{
-			Allowed:   true,
-			Patch:     patch,
-			PatchType: JSONPatch(),
-			Result: &metav1.Status{
-				Code:    http.StatusOK,
-				Message: "success",
-			},
-		}, nil
-	default:
-		errMsg := fmt.Sprintf("[route.Mutating] /disable-service-links: received wrong kind request: %s, Only support Kind: Deployment", request.Kind.Kind)
-		logger.Error(errMsg)
-		return &admissionv1.AdmissionResponse{
-			Allowed: false,
-			Result: &metav1.Status{
-				Code:    http.StatusForbidden,
-				Message: errMsg,
-			},
-		}, nil
+	if deploy.Annotations == nil {
+		deploy.Annotations = make(map[string]string, 1)
 	}
+	deploy.Annotations[disableServiceLinksAnnotation] = "true"
+	enableServiceLinks := false
+	deploy.Spec.Template.Spec.EnableServiceLinks = &enableServiceLinks
+	return deploy, nil
 }
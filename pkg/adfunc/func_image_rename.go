@@ -1,132 +1,80 @@
 package adfunc
 
 import (
+	"context"
 	"fmt"
-	"net/http"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/mritd/goadmission/pkg/conf"
 
-	jsoniter "github.com/json-iterator/go"
-
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-var renameOnce sync.Once
-var renameMap map[string]string
-
 func init() {
 	register(AdmissionFunc{
-		Type: AdmissionTypeMutating,
-		Path: "/rename",
-		Func: rename,
+		Type:       AdmissionTypeMutating,
+		Path:       "/rename",
+		Kinds:      []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Pod")},
+		Operations: []admissionv1.Operation{admissionv1.Create, admissionv1.Update},
+		Func:       Mutate(rename),
 	})
 }
 
-// rename auto modify the image name of the pod
-func rename(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
-	// init rename rules map
-	renameOnce.Do(func() {
-		renameMap = make(map[string]string, 10)
-		for _, s := range conf.ImageRename {
-			ss := strings.Split(s, "=")
-			if len(ss) != 2 {
-				logger.Fatalf("failed to parse image name rename rules: %s", s)
-			}
-			renameMap[ss[0]] = ss[1]
-		}
-	})
+// renameAnnotation records the renamed images of the pod, the key is fixed so that
+// the same pod is always patched the same way
+const renameAnnotation = "rename-mutatingwebhook.mritd.com"
 
-	switch request.Kind.Kind {
-	case "Pod":
-		var pod corev1.Pod
-		err := jsoniter.Unmarshal(request.Object.Raw, &pod)
-		if err != nil {
-			errMsg := fmt.Sprintf("[route.Mutating] /rename: failed to unmarshal object: %v", err)
-			logger.Error(errMsg)
-			return &admissionv1.AdmissionResponse{
-				Allowed: false,
-				Result: &metav1.Status{
-					Code:    http.StatusBadRequest,
-					Message: errMsg,
-				},
-			}, nil
-		}
+// renameRule is an image name rename rule, the image name prefix From is replaced by To
+type renameRule struct {
+	From, To string
+}
 
-		// skip static pod
-		for k := range pod.Annotations {
-			if k == "kubernetes.io/config.mirror" {
-				errMsg := fmt.Sprintf("[route.Mutating] /rename: pod %s has kubernetes.io/config.mirror annotation, skip image rename", pod.Name)
-				logger.Warn(errMsg)
-				return &admissionv1.AdmissionResponse{
-					Allowed: true,
-					Result: &metav1.Status{
-						Code:    http.StatusOK,
-						Message: errMsg,
-					},
-				}, nil
-			}
-		}
+// rename auto modify the image name of the pod
+func rename(ctx context.Context, _ *admissionv1.AdmissionRequest, pod *corev1.Pod) (*corev1.Pod, error) {
+	rules, err := renameRules(conf.FromContext(ctx).Rename.Rules)
+	if err != nil {
+		return nil, err
+	}
 
-		var patches []Patch
-		for i, c := range pod.Spec.Containers {
-			for s, t := range renameMap {
-				if strings.HasPrefix(c.Image, s) {
-					patches = append(patches, Patch{
-						Option: PatchOptionReplace,
-						Path:   fmt.Sprintf("/spec/containers/%d/image", i),
-						Value:  strings.Replace(c.Image, s, t, 1),
-					})
+	// skip static pod
+	if _, ok := pod.Annotations["kubernetes.io/config.mirror"]; ok {
+		Logger(ctx).Warnf("[route.Mutating] /rename: pod %s has kubernetes.io/config.mirror annotation, skip image rename", pod.Name)
+		return nil, nil
+	}
 
-					patches = append(patches, Patch{
-						Option: PatchOptionAdd,
-						Path:   "/metadata/annotations",
-						Value: map[string]string{
-							fmt.Sprintf("rename-mutatingwebhook-%d.mritd.com", time.Now().Unix()): fmt.Sprintf("%d-%s-%s", i, strings.ReplaceAll(s, "/", "_"), strings.ReplaceAll(t, "/", "_")),
-						},
-					})
-					break
-				}
+	var renamed []string
+	for i, c := range pod.Spec.Containers {
+		// the first matched rule in order wins
+		for _, r := range rules {
+			if strings.HasPrefix(c.Image, r.From) {
+				pod.Spec.Containers[i].Image = strings.Replace(c.Image, r.From, r.To, 1)
+				renamed = append(renamed, fmt.Sprintf("%d-%s-%s", i, strings.ReplaceAll(r.From, "/", "_"), strings.ReplaceAll(r.To, "/", "_")))
+				break
 			}
 		}
+	}
+	if len(renamed) == 0 {
+		return nil, nil
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string, 1)
+	}
+	pod.Annotations[renameAnnotation] = strings.Join(renamed, ",")
+	return pod, nil
+}
 
-		patch, err := jsoniter.Marshal(patches)
+// renameRules parses the image name rename rules in order, e.g. "k8s.gcr.io/=gcrxio/k8s.gcr.io_"
+func renameRules(rules []string) ([]renameRule, error) {
+	parsed := make([]renameRule, 0, len(rules))
+	for _, rule := range rules {
+		from, to, err := conf.ParseRenameRule(rule)
 		if err != nil {
-			errMsg := fmt.Sprintf("[route.Mutating] /rename: failed to marshal patch: %v", err)
-			logger.Error(errMsg)
-			return &admissionv1.AdmissionResponse{
-				Allowed: false,
-				Result: &metav1.Status{
-					Code:    http.StatusInternalServerError,
-					Message: errMsg,
-				},
-			}, nil
+			return nil, err
 		}
-
-		logger.Infof("[route.Mutating] /rename: patches: %s", string(patch))
-		return &admissionv1.AdmissionResponse{
-			Allowed:   true,
-			Patch:     patch,
-			PatchType: JSONPatch(),
-			Result: &metav1.Status{
-				Code:    http.StatusOK,
-				Message: "success",
-			},
-		}, nil
-	default:
-		errMsg := fmt.Sprintf("[route.Mutating] /rename: received wrong kind request: %s, Only support Kind: Pod", request.Kind.Kind)
-		logger.Error(errMsg)
-		return &admissionv1.AdmissionResponse{
-			Allowed: false,
-			Result: &metav1.Status{
-				Code:    http.StatusForbidden,
-				Message: errMsg,
-			},
-		}, nil
+		parsed = append(parsed, renameRule{From: from, To: to})
 	}
+	return parsed, nil
 }
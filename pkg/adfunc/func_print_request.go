@@ -1,34 +1,46 @@
 package adfunc
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
 
 	jsoniter "github.com/json-iterator/go"
 	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mritd/goadmission/pkg/conf"
 )
 
 func init() {
 	register(AdmissionFunc{
-		Type: AdmissionTypeMutating,
-		Path: "/print",
-		Func: printRequest,
+		Type:         AdmissionTypeMutating,
+		Path:         "/print",
+		SubResources: []string{"*"},
+		Func:         printRequest,
 	})
 
 	register(AdmissionFunc{
-		Type: AdmissionTypeValidating,
-		Path: "/print",
-		Func: printRequest,
+		Type:         AdmissionTypeValidating,
+		Path:         "/print",
+		SubResources: []string{"*"},
+		Func:         printRequest,
 	})
 }
 
-// printRequest only print admission control request
-func printRequest(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
-	bs, err := jsoniter.MarshalIndent(request, "", "    ")
+// printRequest only print admission control request, the sensitive values are redacted
+func printRequest(ctx context.Context, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	logger := Logger(ctx)
+	bs, err := jsoniter.Marshal(request)
 	if err != nil {
 		return nil, err
 	}
-	logger.Infof("[route.All] /rename: print request:\n%s", string(bs))
+	var out bytes.Buffer
+	if err = json.Indent(&out, logRedactor(conf.FromContext(ctx)).Request(bs), "", "    "); err != nil {
+		return nil, err
+	}
+	logger.Infof("[route.All] /rename: print request:\n%s", out.String())
 
 	return &admissionv1.AdmissionResponse{
 		Allowed: true,
@@ -0,0 +1,101 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// reloadInterval limits how often the key pair files are checked for changes
+const reloadInterval = time.Second
+
+// KeyPairReloader serves the TLS key pair of the cert and key files, the files are
+// reloaded when they change, e.g. when cert-manager updates the mounted secret.
+// The current key pair is kept if the new one fails to load.
+type KeyPairReloader struct {
+	certFile string
+	keyFile  string
+	logger   *zap.SugaredLogger
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+	// certPEM and keyPEM are the last read content of the files, a failed
+	// key pair is not reloaded until the files change again.
+	certPEM   []byte
+	keyPEM    []byte
+	checkedAt time.Time
+}
+
+// NewKeyPairReloader loads the key pair of the cert and key files, it returns an error if the key pair is invalid
+func NewKeyPairReloader(certFile, keyFile string, logger *zap.SugaredLogger) (*KeyPairReloader, error) {
+	r := &KeyPairReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	certPEM, keyPEM, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current key pair, it is used as tls.Config.GetCertificate
+func (r *KeyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= reloadInterval {
+		r.checkedAt = time.Now()
+		r.reload()
+	}
+	return r.cert, nil
+}
+
+// reload loads the key pair if the files are changed, errors are logged
+func (r *KeyPairReloader) reload() {
+	certPEM, keyPEM, err := r.read()
+	if err != nil {
+		r.logger.Errorf("failed to read TLS key pair, the current certificate is kept: %v", err)
+		return
+	}
+	if bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) {
+		return
+	}
+	r.certPEM, r.keyPEM = certPEM, keyPEM
+	if err = r.load(certPEM, keyPEM); err != nil {
+		r.logger.Errorf("failed to reload TLS key pair, the current certificate is kept: %v", err)
+	}
+}
+
+func (r *KeyPairReloader) read() (certPEM, keyPEM []byte, err error) {
+	if certPEM, err = os.ReadFile(r.certFile); err != nil {
+		return nil, nil, err
+	}
+	if keyPEM, err = os.ReadFile(r.keyFile); err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+func (r *KeyPairReloader) load(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid TLS key pair %s, %s: %w", r.certFile, r.keyFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("invalid TLS certificate %s: %w", r.certFile, err)
+	}
+	cert.Leaf = leaf
+
+	r.cert, r.certPEM, r.keyPEM = &cert, certPEM, keyPEM
+	r.logger.Infof("load TLS certificate %s, subject: %s, dns names: %v, expires at %s",
+		r.certFile, leaf.Subject, leaf.DNSNames, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
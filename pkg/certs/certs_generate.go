@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultCAValidity is the default validity of the generated CA
+	DefaultCAValidity = 10 * 365 * 24 * time.Hour
+	// DefaultValidity is the default validity of the generated serving certificate
+	DefaultValidity = 365 * 24 * time.Hour
+
+	caCommonName = "Dynamic Admission Control CA"
+	organization = "Dynamic Admission Control"
+)
+
+// KeyPair is a PEM encoded certificate and private key
+type KeyPair struct {
+	Cert []byte
+	Key  []byte
+}
+
+// CABundle returns the base64 encoded certificate, it is the caBundle of the webhook configurations
+func (kp *KeyPair) CABundle() string {
+	return base64.StdEncoding.EncodeToString(kp.Cert)
+}
+
+// Certificate parses the certificate of the key pair
+func (kp *KeyPair) Certificate() (*x509.Certificate, error) {
+	block, _ := pem.Decode(kp.Cert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to decode certificate PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ServiceHosts returns the DNS names of the service, e.g. "mutating-webhook",
+// "mutating-webhook.kube-addons" and "mutating-webhook.kube-addons.svc"
+func ServiceHosts(service, namespace string) []string {
+	return []string{
+		service,
+		service + "." + namespace,
+		service + "." + namespace + ".svc",
+		service + "." + namespace + ".svc.cluster.local",
+	}
+}
+
+// GenerateCA generates a self-signed CA
+func GenerateCA(validity time.Duration) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	tpl, err := template(caCommonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	tpl.IsCA = true
+	tpl.BasicConstraintsValid = true
+	tpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	return encode(der, key)
+}
+
+// GenerateServing generates a serving certificate of the hosts signed by the CA, hosts
+// are DNS names or IP addresses, the first host is the common name.
+func GenerateServing(ca *KeyPair, hosts []string, validity time.Duration) (*KeyPair, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("serving certificate has no host")
+	}
+	caCert, caKey, err := ca.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	tpl, err := template(hosts[0], validity)
+	if err != nil {
+		return nil, err
+	}
+	tpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return encode(der, key)
+}
+
+func template(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{organization},
+		},
+		// tolerate the clock skew between the generator and the apiserver
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(validity),
+	}, nil
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) (*KeyPair, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	return &KeyPair{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func (kp *KeyPair) parse() (*x509.Certificate, interface{}, error) {
+	cert, err := kp.Certificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(kp.Key)
+	if block == nil {
+		return nil, nil, errors.New("failed to decode key PEM")
+	}
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+	return cert, key, nil
+}
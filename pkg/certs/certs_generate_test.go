@@ -0,0 +1,107 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGenerateServing(t *testing.T) {
+	ca, err := GenerateCA(DefaultCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := append(ServiceHosts("goadmission", "kube-addons"), "127.0.0.1", "::1")
+	serving, err := GenerateServing(ca, hosts, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := serving.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDNS := []string{
+		"goadmission",
+		"goadmission.kube-addons",
+		"goadmission.kube-addons.svc",
+		"goadmission.kube-addons.svc.cluster.local",
+	}
+	if !reflect.DeepEqual(cert.DNSNames, wantDNS) {
+		t.Errorf("dns names = %v, want %v", cert.DNSNames, wantDNS)
+	}
+	if len(cert.IPAddresses) != 2 || !cert.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) || !cert.IPAddresses[1].Equal(net.ParseIP("::1")) {
+		t.Errorf("ip addresses = %v, want 127.0.0.1 and ::1", cert.IPAddresses)
+	}
+	if cert.Subject.CommonName != "goadmission" {
+		t.Errorf("common name = %s, want the first host", cert.Subject.CommonName)
+	}
+
+	caCert, err := ca.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caCert.IsCA {
+		t.Error("CA certificate is not a CA")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	for _, host := range []string{"goadmission.kube-addons.svc", "127.0.0.1"} {
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName:   host,
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			t.Errorf("%s: failed to verify against the CA: %v", host, err)
+		}
+	}
+	if err = cert.VerifyHostname("goadmission.default.svc"); err == nil {
+		t.Error("certificate is valid for a host out of the SANs")
+	}
+
+	if _, err = tls.X509KeyPair(serving.Cert, serving.Key); err != nil {
+		t.Errorf("serving key pair does not match: %v", err)
+	}
+}
+
+func TestGenerateServingOtherCA(t *testing.T) {
+	ca, err := GenerateCA(DefaultCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateCA(DefaultCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serving, err := GenerateServing(ca, []string{"goadmission"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := serving.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, err := other.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(otherCert)
+	if _, err = cert.Verify(x509.VerifyOptions{DNSName: "goadmission", Roots: roots}); err == nil {
+		t.Error("certificate is verified against another CA")
+	}
+}
+
+func TestGenerateServingNoHost(t *testing.T) {
+	ca, err := GenerateCA(DefaultCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GenerateServing(ca, nil, time.Hour); err == nil {
+		t.Error("serving certificate without hosts is generated")
+	}
+}
@@ -0,0 +1,322 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultRotateBefore is the default time before expiry to rotate the certificates
+	DefaultRotateBefore = 30 * 24 * time.Hour
+	// DefaultCheckInterval is the default interval to check the certificates
+	DefaultCheckInterval = time.Hour
+
+	// SecretCAKey is the Secret key of the CA bundle, the current CA is the first
+	// certificate, followed by the previous CA during CA rotation.
+	SecretCAKey = "ca.crt"
+	// SecretCAPrivateKey is the Secret key of the current CA key
+	SecretCAPrivateKey = "ca.key"
+)
+
+// Manager manages the CA and serving certificate stored in a Secret, the certificates
+// are created if the Secret does not exist and rotated before expiry. The CA bundle is
+// patched into the webhooks of the named webhook configurations that call the services.
+// The replicas sharing the Secret serve the same certificate.
+type Manager struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// Secret is the name of the Secret storing the certificates
+	Secret string
+	// Services is the webhook services, the service DNS names are added to the certificate
+	Services []string
+	// Hosts is the extra DNS names or IP addresses of the certificate
+	Hosts []string
+	// MutatingWebhooks and ValidatingWebhooks are the names of the webhook configurations to patch caBundle into
+	MutatingWebhooks   []string
+	ValidatingWebhooks []string
+	// CAValidity and Validity are DefaultCAValidity and DefaultValidity if they are zero
+	CAValidity time.Duration
+	Validity   time.Duration
+	// RotateBefore is DefaultRotateBefore if it is zero
+	RotateBefore time.Duration
+	// CheckInterval is DefaultCheckInterval if it is zero
+	CheckInterval time.Duration
+	Logger        *zap.SugaredLogger
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// Start ensures the certificates and the caBundle once, then keeps them up to date
+// until ctx is done. It returns an error if the first check fails.
+func (m *Manager) Start(ctx context.Context) error {
+	if m.CAValidity == 0 {
+		m.CAValidity = DefaultCAValidity
+	}
+	if m.Validity == 0 {
+		m.Validity = DefaultValidity
+	}
+	if m.RotateBefore == 0 {
+		m.RotateBefore = DefaultRotateBefore
+	}
+	if m.CheckInterval == 0 {
+		m.CheckInterval = DefaultCheckInterval
+	}
+	if m.Logger == nil {
+		m.Logger = zap.NewNop().Sugar()
+	}
+	if len(m.Services) == 0 {
+		return errors.New("certificate manager has no service")
+	}
+
+	if err := m.Ensure(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(m.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Ensure(ctx); err != nil {
+					m.Logger.Errorf("failed to ensure certificates, the current certificate is kept: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// GetCertificate returns the current serving certificate, it is used as tls.Config.GetCertificate
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("serving certificate is not ready")
+	}
+	return m.cert, nil
+}
+
+// Ensure creates or rotates the certificates in the Secret, loads the serving
+// certificate and patches the CA bundle into the webhook configurations.
+func (m *Manager) Ensure(ctx context.Context) error {
+	secret, err := m.ensureSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("invalid serving certificate in secret %s/%s: %w", m.Namespace, m.Secret, err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("invalid serving certificate in secret %s/%s: %w", m.Namespace, m.Secret, err)
+	}
+	m.mu.Lock()
+	if m.cert == nil || !bytes.Equal(m.cert.Certificate[0], cert.Certificate[0]) {
+		m.Logger.Infof("load serving certificate from secret %s/%s, dns names: %v, expires at %s",
+			m.Namespace, m.Secret, cert.Leaf.DNSNames, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	m.cert = &cert
+	m.mu.Unlock()
+
+	return m.injectCABundle(ctx, secret.Data[SecretCAKey])
+}
+
+// ensureSecret returns the Secret of valid certificates, the Secret is created or updated if needed
+func (m *Manager) ensureSecret(ctx context.Context) (*corev1.Secret, error) {
+	secrets := m.Client.CoreV1().Secrets(m.Namespace)
+	secret, err := secrets.Get(ctx, m.Secret, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: m.Secret, Namespace: m.Namespace},
+			Type:       corev1.SecretTypeTLS,
+		}
+		if err = m.rotate(secret); err != nil {
+			return nil, err
+		}
+		created, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// created by another replica
+			return secrets.Get(ctx, m.Secret, metav1.GetOptions{})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create secret %s/%s: %w", m.Namespace, m.Secret, err)
+		}
+		m.Logger.Infof("create certificates in secret %s/%s", m.Namespace, m.Secret)
+		return created, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", m.Namespace, m.Secret, err)
+	}
+
+	secret = secret.DeepCopy()
+	reason := m.needRotate(secret)
+	if reason == "" {
+		return secret, nil
+	}
+	m.Logger.Infof("rotate certificates in secret %s/%s: %s", m.Namespace, m.Secret, reason)
+	if err = m.rotate(secret); err != nil {
+		return nil, err
+	}
+	updated, err := secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		// rotated by another replica
+		return secrets.Get(ctx, m.Secret, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update secret %s/%s: %w", m.Namespace, m.Secret, err)
+	}
+	return updated, nil
+}
+
+// needRotate returns the reason to rotate the certificates of the Secret, or "" if they are valid
+func (m *Manager) needRotate(secret *corev1.Secret) string {
+	ca := &KeyPair{Cert: secret.Data[SecretCAKey], Key: secret.Data[SecretCAPrivateKey]}
+	caCert, _, err := ca.parse()
+	if err != nil {
+		return fmt.Sprintf("invalid CA: %v", err)
+	}
+	if time.Until(caCert.NotAfter) < m.RotateBefore {
+		return fmt.Sprintf("CA expires at %s", caCert.NotAfter.Format(time.RFC3339))
+	}
+
+	serving := &KeyPair{Cert: secret.Data[corev1.TLSCertKey]}
+	cert, err := serving.Certificate()
+	if err != nil {
+		return fmt.Sprintf("invalid serving certificate: %v", err)
+	}
+	if time.Until(cert.NotAfter) < m.RotateBefore {
+		return fmt.Sprintf("serving certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+	if err = cert.CheckSignatureFrom(caCert); err != nil {
+		return "serving certificate is not signed by the CA"
+	}
+	for _, h := range m.hosts() {
+		if err = cert.VerifyHostname(h); err != nil {
+			return fmt.Sprintf("serving certificate does not cover %s", h)
+		}
+	}
+	return ""
+}
+
+// rotate generates the certificates of the Secret, the CA is kept if it is valid, and the
+// previous CA stays in the CA bundle during CA rotation so that the old serving certificate
+// is still trusted until every replica loads the new one.
+func (m *Manager) rotate(secret *corev1.Secret) error {
+	ca := &KeyPair{Cert: secret.Data[SecretCAKey], Key: secret.Data[SecretCAPrivateKey]}
+	bundle := secret.Data[SecretCAKey]
+	caCert, _, err := ca.parse()
+	if err != nil || time.Until(caCert.NotAfter) < m.RotateBefore {
+		newCA, genErr := GenerateCA(m.CAValidity)
+		if genErr != nil {
+			return genErr
+		}
+		bundle = newCA.Cert
+		if err == nil && time.Now().Before(caCert.NotAfter) {
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})...)
+		}
+		ca = newCA
+	}
+
+	serving, err := GenerateServing(ca, m.hosts(), m.Validity)
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[SecretCAKey] = bundle
+	secret.Data[SecretCAPrivateKey] = ca.Key
+	secret.Data[corev1.TLSCertKey] = serving.Cert
+	secret.Data[corev1.TLSPrivateKeyKey] = serving.Key
+	return nil
+}
+
+func (m *Manager) hosts() []string {
+	var hosts []string
+	for _, svc := range m.Services {
+		hosts = append(hosts, ServiceHosts(svc, m.Namespace)...)
+	}
+	return append(hosts, m.Hosts...)
+}
+
+// calls reports whether the webhook client config calls one of the services
+func (m *Manager) calls(cc admissionregistrationv1.WebhookClientConfig) bool {
+	if cc.Service == nil || cc.Service.Namespace != m.Namespace {
+		return false
+	}
+	for _, svc := range m.Services {
+		if cc.Service.Name == svc {
+			return true
+		}
+	}
+	return false
+}
+
+// injectCABundle patches the CA bundle into the webhooks that call the services
+func (m *Manager) injectCABundle(ctx context.Context, bundle []byte) error {
+	var errs []error
+	for _, name := range m.MutatingWebhooks {
+		client := m.Client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+		cfg, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get mutating webhook configuration %s: %w", name, err))
+			continue
+		}
+		changed := false
+		for i := range cfg.Webhooks {
+			if cc := &cfg.Webhooks[i].ClientConfig; m.calls(*cc) && !bytes.Equal(cc.CABundle, bundle) {
+				cc.CABundle, changed = bundle, true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if _, err = client.Update(ctx, cfg, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update mutating webhook configuration %s: %w", name, err))
+			continue
+		}
+		m.Logger.Infof("inject caBundle into mutating webhook configuration %s", name)
+	}
+	for _, name := range m.ValidatingWebhooks {
+		client := m.Client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+		cfg, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get validating webhook configuration %s: %w", name, err))
+			continue
+		}
+		changed := false
+		for i := range cfg.Webhooks {
+			if cc := &cfg.Webhooks[i].ClientConfig; m.calls(*cc) && !bytes.Equal(cc.CABundle, bundle) {
+				cc.CABundle, changed = bundle, true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if _, err = client.Update(ctx, cfg, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update validating webhook configuration %s: %w", name, err))
+			continue
+		}
+		m.Logger.Infof("inject caBundle into validating webhook configuration %s", name)
+	}
+	return errors.Join(errs...)
+}
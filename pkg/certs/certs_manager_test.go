@@ -0,0 +1,256 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace = "kube-addons"
+	testSecret    = "goadmission-certs"
+	testService   = "goadmission"
+)
+
+func newTestManager(objects ...runtime.Object) *Manager {
+	return &Manager{
+		Client:    fake.NewSimpleClientset(objects...),
+		Namespace: testNamespace,
+		Secret:    testSecret,
+		Services:  []string{testService},
+	}
+}
+
+// startTestManager starts the manager, it is stopped when the test ends
+func startTestManager(t *testing.T, m *Manager) *corev1.Secret {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("failed to start manager: %v", err)
+	}
+	return getSecret(t, m)
+}
+
+func getSecret(t *testing.T, m *Manager) *corev1.Secret {
+	t.Helper()
+	secret, err := m.Client.CoreV1().Secrets(testNamespace).Get(context.Background(), testSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// testSecretObject returns a Secret of the certificates signed by a CA valid for caValidity
+func testSecretObject(t *testing.T, caValidity, validity time.Duration) *corev1.Secret {
+	t.Helper()
+	ca, err := GenerateCA(caValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serving, err := GenerateServing(ca, ServiceHosts(testService, testNamespace), validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testSecret},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			SecretCAKey:             ca.Cert,
+			SecretCAPrivateKey:      ca.Key,
+			corev1.TLSCertKey:       serving.Cert,
+			corev1.TLSPrivateKeyKey: serving.Key,
+		},
+	}
+}
+
+// parseBundle parses the certificates of the CA bundle
+func parseBundle(t *testing.T, bundle []byte) []*x509.Certificate {
+	t.Helper()
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// verifyServing verifies the served certificate against the CA bundle of the Secret
+func verifyServing(t *testing.T, m *Manager, secret *corev1.Secret) {
+	t.Helper()
+	cert, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), secret.Data[corev1.TLSCertKey]) {
+		t.Error("served certificate is not the certificate of the secret")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(secret.Data[SecretCAKey]) {
+		t.Fatal("invalid CA bundle")
+	}
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{
+		DNSName: testService + "." + testNamespace + ".svc",
+		Roots:   roots,
+	})
+	if err != nil {
+		t.Errorf("served certificate is not trusted by the CA bundle: %v", err)
+	}
+}
+
+func TestManagerCreateSecret(t *testing.T) {
+	m := newTestManager()
+	secret := startTestManager(t, m)
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("secret type = %s, want %s", secret.Type, corev1.SecretTypeTLS)
+	}
+	for _, k := range []string{SecretCAKey, SecretCAPrivateKey, corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if len(secret.Data[k]) == 0 {
+			t.Errorf("secret has no %s", k)
+		}
+	}
+	verifyServing(t, m, secret)
+}
+
+func TestManagerReuseSecret(t *testing.T) {
+	existing := testSecretObject(t, DefaultCAValidity, DefaultValidity)
+	m := newTestManager(existing)
+	secret := startTestManager(t, m)
+	for k, v := range existing.Data {
+		if !bytes.Equal(secret.Data[k], v) {
+			t.Errorf("%s of the valid secret is changed", k)
+		}
+	}
+	verifyServing(t, m, secret)
+}
+
+func TestManagerRotate(t *testing.T) {
+	t.Run("serving certificate", func(t *testing.T) {
+		existing := testSecretObject(t, DefaultCAValidity, 24*time.Hour)
+		m := newTestManager(existing)
+		secret := startTestManager(t, m)
+		if bytes.Equal(secret.Data[corev1.TLSCertKey], existing.Data[corev1.TLSCertKey]) {
+			t.Error("serving certificate expiring soon is not rotated")
+		}
+		if !bytes.Equal(secret.Data[SecretCAKey], existing.Data[SecretCAKey]) {
+			t.Error("valid CA is rotated along with the serving certificate")
+		}
+		verifyServing(t, m, secret)
+	})
+
+	t.Run("CA", func(t *testing.T) {
+		existing := testSecretObject(t, 24*time.Hour, 24*time.Hour)
+		m := newTestManager(existing)
+		secret := startTestManager(t, m)
+		if bytes.Equal(secret.Data[SecretCAPrivateKey], existing.Data[SecretCAPrivateKey]) {
+			t.Error("CA expiring soon is not rotated")
+		}
+		bundle := parseBundle(t, secret.Data[SecretCAKey])
+		previous := parseBundle(t, existing.Data[SecretCAKey])
+		if len(bundle) != 2 || !bundle[1].Equal(previous[0]) {
+			t.Errorf("CA bundle has %d certificates, want the new CA followed by the previous CA", len(bundle))
+		}
+		verifyServing(t, m, secret)
+	})
+
+	t.Run("not covered service", func(t *testing.T) {
+		existing := testSecretObject(t, DefaultCAValidity, DefaultValidity)
+		m := newTestManager(existing)
+		m.Services = append(m.Services, "goadmission-validating")
+		secret := startTestManager(t, m)
+		if bytes.Equal(secret.Data[corev1.TLSCertKey], existing.Data[corev1.TLSCertKey]) {
+			t.Error("serving certificate not covering the services is not rotated")
+		}
+		cert, err := m.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cert.Leaf.VerifyHostname("goadmission-validating." + testNamespace + ".svc"); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestManagerInjectCABundle(t *testing.T) {
+	service := func(name string) admissionregistrationv1.WebhookClientConfig {
+		return admissionregistrationv1.WebhookClientConfig{
+			Service:  &admissionregistrationv1.ServiceReference{Namespace: testNamespace, Name: name},
+			CABundle: []byte("stale"),
+		}
+	}
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "goadmission-mutating"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "rename.mritd.com", ClientConfig: service(testService)},
+			{Name: "other.example.com", ClientConfig: service("other")},
+		},
+	}
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "goadmission-validating"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "check-deploy-time.mritd.com", ClientConfig: service(testService)},
+		},
+	}
+	m := newTestManager(mutating, validating)
+	m.MutatingWebhooks = []string{mutating.Name}
+	m.ValidatingWebhooks = []string{validating.Name}
+	secret := startTestManager(t, m)
+	bundle := secret.Data[SecretCAKey]
+
+	ctx := context.Background()
+	mutating, err := m.Client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, mutating.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mutating.Webhooks[0].ClientConfig.CABundle, bundle) {
+		t.Error("caBundle is not injected into the mutating webhook")
+	}
+	if string(mutating.Webhooks[1].ClientConfig.CABundle) != "stale" {
+		t.Error("caBundle is injected into the mutating webhook of another service")
+	}
+	validating, err = m.Client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, validating.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(validating.Webhooks[0].ClientConfig.CABundle, bundle) {
+		t.Error("caBundle is not injected into the validating webhook")
+	}
+
+	// the webhook configurations are not updated again if the caBundle is up to date
+	client := m.Client.(*fake.Clientset)
+	client.ClearActions()
+	if err = m.Ensure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("unexpected update of %s", action.GetResource().Resource)
+		}
+	}
+}
+
+func TestManagerMissingWebhookConfiguration(t *testing.T) {
+	m := newTestManager()
+	m.MutatingWebhooks = []string{"goadmission-mutating"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err == nil {
+		t.Error("missing webhook configurations are not reported")
+	}
+}
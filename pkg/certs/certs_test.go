@@ -0,0 +1,120 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// selfSigned returns the PEM encoded self-signed certificate and key of the common name
+func selfSigned(t *testing.T, commonName string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, file string, bs []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, bs, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedName returns the common name of the certificate served by the reloader,
+// the files are checked for changes regardless of reloadInterval
+func servedName(t *testing.T, r *KeyPairReloader) string {
+	t.Helper()
+	r.mu.Lock()
+	r.checkedAt = time.Time{}
+	r.mu.Unlock()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestKeyPairReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	oldCert, oldKey := selfSigned(t, "old.example.com")
+	newCert, newKey := selfSigned(t, "new.example.com")
+	writeFile(t, certFile, oldCert)
+	writeFile(t, keyFile, oldKey)
+
+	r, err := NewKeyPairReloader(certFile, keyFile, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, r); name != "old.example.com" {
+		t.Fatalf("served %s, want the loaded certificate", name)
+	}
+
+	tests := []struct {
+		name string
+		cert []byte
+		key  []byte
+		want string
+	}{
+		{"half-written cert", newCert[:len(newCert)/2], newKey, "old.example.com"},
+		{"cert updated before key", newCert, oldKey, "old.example.com"},
+		{"corrupt key", newCert, []byte("not a key"), "old.example.com"},
+		{"new key pair", newCert, newKey, "new.example.com"},
+		{"key updated before cert", newCert, oldKey, "new.example.com"},
+	}
+	for _, tt := range tests {
+		writeFile(t, certFile, tt.cert)
+		writeFile(t, keyFile, tt.key)
+		if name := servedName(t, r); name != tt.want {
+			t.Errorf("%s: served %s, want %s", tt.name, name, tt.want)
+		}
+	}
+
+	if err = os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, r); name != "new.example.com" {
+		t.Errorf("served %s after the key file is removed, want the current certificate", name)
+	}
+}
+
+func TestNewKeyPairReloaderInvalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	cert, _ := selfSigned(t, "example.com")
+	_, key := selfSigned(t, "example.com")
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+	if _, err := NewKeyPairReloader(certFile, keyFile, zap.NewNop().Sugar()); err == nil {
+		t.Error("mismatched key pair is loaded")
+	}
+}
@@ -1,12 +1,93 @@
 package conf
 
-var (
-	Cert string
-	Key  string
-	Addr string
+import (
+	"context"
+	"fmt"
+	"strings"
 )
 
-var ImageRename []string
+// Config is the configuration of an admission server and its builtin admission funcs
+type Config struct {
+	Cert string `json:"cert,omitempty" yaml:"cert,omitempty"`
+	Key  string `json:"key,omitempty" yaml:"key,omitempty"`
+	Addr string `json:"listen,omitempty" yaml:"listen,omitempty"`
+
+	Rename              RenameConfig              `json:"rename" yaml:"rename"`
+	CheckDeployTime     CheckDeployTimeConfig     `json:"check_deploy_time" yaml:"check_deploy_time"`
+	DisableServiceLinks DisableServiceLinksConfig `json:"disable_service_links" yaml:"disable_service_links"`
+
+	// Funcs is the runtime settings of admission funcs keyed by handle path,
+	// e.g. "/validating/check-deploy-time"
+	Funcs map[string]FuncConfig `json:"funcs,omitempty" yaml:"funcs,omitempty"`
+
+	// Pipelines is the stages of mutating pipelines keyed by pipeline name,
+	// e.g. "default" => ["rename", "disable-service-links"]
+	Pipelines map[string][]string `json:"pipelines,omitempty" yaml:"pipelines,omitempty"`
+
+	Record RecordConfig `json:"record" yaml:"record"`
+	Redact RedactConfig `json:"redact" yaml:"redact"`
+}
+
+// RenameConfig is the config of the /mutating/rename admission func
+type RenameConfig struct {
+	// Rules is the image name rename rules, e.g. "k8s.gcr.io/=gcrxio/k8s.gcr.io_"
+	Rules []string `json:"rules" yaml:"rules"`
+}
+
+// CheckDeployTimeConfig is the config of the /validating/check-deploy-time admission func
+type CheckDeployTimeConfig struct {
+	// AllowTime is the time windows that allow deployment, e.g. "05:00~10:00"
+	AllowTime []string `json:"allow_time" yaml:"allow_time"`
+	// ForceLabel is the label that allows deployment at any time
+	ForceLabel string `json:"force_label" yaml:"force_label"`
+}
+
+// DisableServiceLinksConfig is the config of the /mutating/disable-service-links admission func
+type DisableServiceLinksConfig struct {
+	// ForceEnableLabel is the label that keeps service links enabled
+	ForceEnableLabel string `json:"force_enable_label" yaml:"force_enable_label"`
+}
+
+// FuncConfig is the runtime settings of an admission func
+type FuncConfig struct {
+	// Mode is the enforcement mode, "enforce", "warn", "audit" or "dry-run"
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// Timeout is the timeout of the admission func, e.g. "2s"
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// TimeoutFallback is the decision when the admission func times out,
+	// "allow", "deny", "allow:<message>" or "deny:<message>"
+	TimeoutFallback string `json:"timeout_fallback,omitempty" yaml:"timeout_fallback,omitempty"`
+	// PanicFallback is the decision when the admission func panics,
+	// the format is the same as TimeoutFallback
+	PanicFallback string `json:"panic_fallback,omitempty" yaml:"panic_fallback,omitempty"`
+}
+
+// RecordConfig is the settings of recording the admission reviews as JSONL files
+type RecordConfig struct {
+	// Dir is the directory of the record files, recording is disabled if it is empty
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// SampleRate is the fraction of the admission reviews recorded, between 0 and 1
+	SampleRate float64 `json:"sample_rate" yaml:"sample_rate"`
+	// Funcs is the handle paths of the admission funcs and pipelines recorded,
+	// e.g. "/mutating/rename", empty means all
+	Funcs []string `json:"funcs,omitempty" yaml:"funcs,omitempty"`
+	// Responses records the responses along with the requests
+	Responses bool `json:"responses,omitempty" yaml:"responses,omitempty"`
+}
+
+// RedactConfig is the settings of redacting the sensitive values of the logged admission reviews,
+// e.g. Secret data and environment variables like "DB_PASSWORD"
+type RedactConfig struct {
+	// Disabled logs the admission reviews as they are at debug level, the recorded
+	// admission reviews are always redacted
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// Paths is the JSON pointers of the extra values redacted in the objects, "*" matches
+	// any key or index, e.g. "/spec/containers/*/args"
+	Paths []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+var DefaultAddr = ":443"
+
 var DefaultImageRenameRules = []string{
 	"k8s.gcr.io/=gcrxio/k8s.gcr.io_",
 	"gcr.io/kubernetes-helm/=gcrxio/gcr.io_kubernetes-helm_",
@@ -18,14 +99,80 @@ var DefaultImageRenameRules = []string{
 	"gcr.io/knative-releases/=gcrxio/gcr.io_knative-releases_",
 }
 
-var ForceDeployLabel string
 var DefaultForceDeployLabel = "force-deploy.mritd.com"
 
-var AllowDeployTime []string
 var DefaultAllowDeployTime = []string{
 	"05:00~10:00",
 	"14:00~15:00",
 }
 
-var ForceEnableServiceLinksLabel string
 var DefaultForceEnableServiceLinksLabel = "force-enable-service-links.mritd.com"
+
+// Default returns a Config filled with the default values
+func Default() *Config {
+	return &Config{
+		Addr: DefaultAddr,
+		Rename: RenameConfig{
+			Rules: append([]string(nil), DefaultImageRenameRules...),
+		},
+		CheckDeployTime: CheckDeployTimeConfig{
+			AllowTime:  append([]string(nil), DefaultAllowDeployTime...),
+			ForceLabel: DefaultForceDeployLabel,
+		},
+		DisableServiceLinks: DisableServiceLinksConfig{
+			ForceEnableLabel: DefaultForceEnableServiceLinksLabel,
+		},
+		Funcs:     make(map[string]FuncConfig),
+		Pipelines: make(map[string][]string),
+		Record: RecordConfig{
+			SampleRate: 1,
+		},
+	}
+}
+
+// Func returns the runtime settings of the admission func
+func (c *Config) Func(handlePath string) FuncConfig {
+	return c.Funcs[handlePath]
+}
+
+// SetFunc updates the runtime settings of the admission func
+func (c *Config) SetFunc(handlePath string, update func(fc *FuncConfig)) {
+	if c.Funcs == nil {
+		c.Funcs = make(map[string]FuncConfig)
+	}
+	fc := c.Funcs[handlePath]
+	update(&fc)
+	c.Funcs[handlePath] = fc
+}
+
+// ParsePipelines parses the pipeline definitions, e.g. "default=rename,disable-service-links"
+func ParsePipelines(defs []string) (map[string][]string, error) {
+	pipelines := make(map[string][]string, len(defs))
+	for _, def := range defs {
+		ss := strings.SplitN(def, "=", 2)
+		if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+			return nil, fmt.Errorf("failed to parse pipeline: %s", def)
+		}
+		if _, ok := pipelines[ss[0]]; ok {
+			return nil, fmt.Errorf("pipeline %s is defined more than once", ss[0])
+		}
+		pipelines[ss[0]] = strings.Split(ss[1], ",")
+	}
+	return pipelines, nil
+}
+
+type configKey struct{}
+
+// NewContext returns a copy of ctx that carries the config
+func NewContext(ctx context.Context, c *Config) context.Context {
+	return context.WithValue(ctx, configKey{}, c)
+}
+
+// FromContext returns the config carried by ctx, the default config is
+// returned if ctx does not carry one.
+func FromContext(ctx context.Context) *Config {
+	if c, ok := ctx.Value(configKey{}).(*Config); ok && c != nil {
+		return c
+	}
+	return Default()
+}
@@ -0,0 +1,131 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultConfigMapKey is the default key of the config in the ConfigMap
+const DefaultConfigMapKey = "config.yaml"
+
+// ConfigMapSource watches the config stored in a ConfigMap key, so that the
+// webhook replicas share the same config. The data is applied after the config
+// file and before the environment variables, see Load.
+type ConfigMapSource struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	// Key is the key of the config in the ConfigMap, DefaultConfigMapKey is used if it is empty
+	Key    string
+	Logger *zap.SugaredLogger
+
+	changes chan struct{}
+	mu      sync.RWMutex
+	data    []byte
+	err     error
+}
+
+// ParseConfigMapRef parses the ConfigMap reference "<namespace>/<name>"
+func ParseConfigMapRef(ref string) (namespace, name string, err error) {
+	ss := strings.Split(ref, "/")
+	if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+		return "", "", fmt.Errorf("configmap must be formatted as <namespace>/<name>: %s", ref)
+	}
+	return ss[0], ss[1], nil
+}
+
+// Start starts the informer of the ConfigMap and waits for the first sync, it returns an
+// error if the ConfigMap does not exist. The informer is stopped when ctx is done.
+func (s *ConfigMapSource) Start(ctx context.Context) error {
+	if s.Key == "" {
+		s.Key = DefaultConfigMapKey
+	}
+	if s.Logger == nil {
+		s.Logger = zap.NewNop().Sugar()
+	}
+	s.changes = make(chan struct{}, 1)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(s.Client, 10*time.Minute,
+		informers.WithNamespace(s.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.Name).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.update(obj) },
+		UpdateFunc: func(_, obj interface{}) { s.update(obj) },
+		DeleteFunc: func(interface{}) {
+			s.Logger.Warnf("configmap %s/%s is deleted, the last config is kept", s.Namespace, s.Name)
+		},
+	})
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync configmap %s/%s", s.Namespace, s.Name)
+	}
+	// the config of the first sync is loaded by the caller
+	select {
+	case <-s.changes:
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil && s.err == nil {
+		return fmt.Errorf("configmap %s/%s is not found", s.Namespace, s.Name)
+	}
+	return s.err
+}
+
+// Changes returns the channel signaled when the config in the ConfigMap changes after Start
+func (s *ConfigMapSource) Changes() <-chan struct{} {
+	return s.changes
+}
+
+// Data returns the config in the ConfigMap, or the error if the ConfigMap has no config key
+func (s *ConfigMapSource) Data() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data, s.err
+}
+
+func (s *ConfigMapSource) update(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || cm.Name != s.Name {
+		return
+	}
+
+	var data []byte
+	var err error
+	if v, ok := cm.Data[s.Key]; ok {
+		data = []byte(v)
+	} else if v, ok := cm.BinaryData[s.Key]; ok {
+		data = v
+	} else {
+		err = fmt.Errorf("configmap %s/%s has no key %s", s.Namespace, s.Name, s.Key)
+	}
+
+	s.mu.Lock()
+	changed := err != nil || s.err != nil || string(data) != string(s.data)
+	s.data, s.err = data, err
+	s.mu.Unlock()
+
+	if changed {
+		select {
+		case s.changes <- struct{}{}:
+		default:
+		}
+	}
+}
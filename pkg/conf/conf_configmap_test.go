@@ -0,0 +1,229 @@
+package conf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace = "kube-addons"
+	testConfigMap = "goadmission"
+)
+
+func testConfigMapObject(config string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testConfigMap},
+		Data:       map[string]string{DefaultConfigMapKey: config},
+	}
+}
+
+// testConfigFile writes the config file shared by the ConfigMap tests
+func testConfigFile(t *testing.T) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+listen: ":8000"
+check_deploy_time:
+  force_label: file-label
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// startConfigMapSource starts the source of the fake client, it is stopped when the test ends
+func startConfigMapSource(t *testing.T, client *fake.Clientset) (*ConfigMapSource, error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	source := &ConfigMapSource{Client: client, Namespace: testNamespace, Name: testConfigMap}
+	return source, source.Start(ctx)
+}
+
+// waitChange waits for the change signal of the source
+func waitChange(t *testing.T, source *ConfigMapSource) {
+	t.Helper()
+	select {
+	case <-source.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("configmap change is not signaled")
+	}
+}
+
+// eventually waits for the condition to be true
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfigMapSourceLoad(t *testing.T) {
+	file := testConfigFile(t)
+	client := fake.NewSimpleClientset(testConfigMapObject("rename:\n  rules: [\"docker.io/=mirror.local/\"]\n"))
+	source, err := startConfigMapSource(t, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := source.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(file, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Rename.Rules) != 1 || c.Rename.Rules[0] != "docker.io/=mirror.local/" {
+		t.Errorf("rename rules = %v, want the rules of the configmap", c.Rename.Rules)
+	}
+	if c.Addr != ":8000" || c.CheckDeployTime.ForceLabel != "file-label" {
+		t.Errorf("listen = %s, force_label = %s, want the values of the config file", c.Addr, c.CheckDeployTime.ForceLabel)
+	}
+
+	// the config of the configmap is applied over the config file
+	cm := testConfigMapObject("check_deploy_time:\n  force_label: configmap-label\n")
+	if _, err = client.CoreV1().ConfigMaps(testNamespace).Update(context.Background(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, source)
+	if data, err = source.Data(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = Load(file, data); err != nil {
+		t.Fatal(err)
+	}
+	if c.CheckDeployTime.ForceLabel != "configmap-label" || c.Addr != ":8000" {
+		t.Errorf("listen = %s, force_label = %s, want the listen address of the config file and the label of the configmap",
+			c.Addr, c.CheckDeployTime.ForceLabel)
+	}
+	if len(c.Rename.Rules) != len(DefaultImageRenameRules) {
+		t.Errorf("rename rules = %v, want the rules removed from the configmap to be the defaults", c.Rename.Rules)
+	}
+}
+
+func TestConfigMapSourceDeleted(t *testing.T) {
+	client := fake.NewSimpleClientset(testConfigMapObject("listen: \":9000\"\n"))
+	source, err := startConfigMapSource(t, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.CoreV1().ConfigMaps(testNamespace).Delete(context.Background(), testConfigMap, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-source.Changes():
+		t.Error("deleted configmap is signaled as a change")
+	case <-time.After(200 * time.Millisecond):
+	}
+	data, err := source.Data()
+	if err != nil || string(data) != "listen: \":9000\"\n" {
+		t.Errorf("Data() = %q, %v, want the last config", data, err)
+	}
+}
+
+func TestConfigMapSourceMissing(t *testing.T) {
+	if _, err := startConfigMapSource(t, fake.NewSimpleClientset()); err == nil {
+		t.Error("missing configmap is started")
+	}
+
+	cm := testConfigMapObject("")
+	cm.Data = map[string]string{"other.yaml": "listen: \":9000\"\n"}
+	if _, err := startConfigMapSource(t, fake.NewSimpleClientset(cm)); err == nil {
+		t.Error("configmap without the config key is started")
+	}
+}
+
+func TestConfigMapSourceInvalid(t *testing.T) {
+	file := testConfigFile(t)
+	client := fake.NewSimpleClientset(testConfigMapObject("check_deploy_time:\n  allow_time: [\"05:00~10:00\"]\n"))
+	source, err := startConfigMapSource(t, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := func() (*Config, error) {
+		data, err := source.Data()
+		if err != nil {
+			return nil, err
+		}
+		return Load(file, data)
+	}
+	current, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	core, logs := observer.New(zap.InfoLevel)
+	watcher := &Watcher{
+		Interval: -1,
+		Changes:  source.Changes(),
+		Load:     load,
+		Apply: func(c *Config) error {
+			if err := c.Validate(); err != nil {
+				return err
+			}
+			mu.Lock()
+			current = c
+			mu.Unlock()
+			return nil
+		},
+		Logger: zap.New(core).Sugar(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	update := func(cm *corev1.ConfigMap) {
+		t.Helper()
+		if _, err := client.CoreV1().ConfigMaps(testNamespace).Update(context.Background(), cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	currentAllowTime := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return current.CheckDeployTime.AllowTime
+	}
+
+	tests := []struct {
+		name string
+		cm   *corev1.ConfigMap
+		log  string
+	}{
+		{"unknown field", testConfigMapObject("check_deploy_time:\n  unknown: true\n"), "failed to load config"},
+		{"invalid allow time", testConfigMapObject("check_deploy_time:\n  allow_time: [\"25:00~10:00\"]\n"), "failed to apply config"},
+		{"missing key", &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testConfigMap}}, "failed to load config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := logs.FilterMessageSnippet(tt.log).Len()
+			update(tt.cm)
+			eventually(t, "invalid config is not rejected", func() bool { return logs.FilterMessageSnippet(tt.log).Len() > n })
+			if allowTime := currentAllowTime(); len(allowTime) != 1 || allowTime[0] != "05:00~10:00" {
+				t.Errorf("allow time = %v, want the last good config", allowTime)
+			}
+		})
+	}
+
+	// a valid config is applied again
+	update(testConfigMapObject("check_deploy_time:\n  allow_time: [\"11:00~12:00\"]\n"))
+	eventually(t, "valid config is not applied", func() bool {
+		allowTime := currentAllowTime()
+		return len(allowTime) == 1 && allowTime[0] == "11:00~12:00"
+	})
+}
@@ -0,0 +1,196 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Flags binds the config sources and the config to the command line flags, the
+// flags set on the command line override the config file, the ConfigMap and the
+// GOADMISSION_* environment variables, see Load.
+type Flags struct {
+	// File is the config file, it is skipped if it is empty
+	File string
+	// ConfigMap is the ConfigMap config source "<namespace>/<name>", it is skipped if it is empty
+	ConfigMap    string
+	ConfigMapKey string
+
+	// flagCfg holds the flag values, only the changed flags are applied
+	flagCfg          *Config
+	modes            map[string]string
+	timeouts         map[string]string
+	timeoutFallbacks map[string]string
+	panicFallbacks   map[string]string
+	pipelines        []string
+
+	flags  *pflag.FlagSet
+	source *ConfigMapSource
+}
+
+// AddFlags registers the config flags to the flag set, the flags of the commands
+// parsing them must share the flag set, e.g. the persistent flags of the root command.
+func (f *Flags) AddFlags(fs *pflag.FlagSet) {
+	f.flags = fs
+	f.flagCfg = Default()
+
+	// config sources
+	fs.StringVarP(&f.File, "config", "c", "", "Config file (YAML or JSON), e.g. /etc/goadmission/config.yaml")
+	fs.StringVar(&f.ConfigMap, "configmap", "", "ConfigMap config source (<namespace>/<name>), it is applied after the config file and watched for changes")
+	fs.StringVar(&f.ConfigMapKey, "configmap-key", DefaultConfigMapKey, "Key of the config in the ConfigMap")
+
+	// webhook
+	fs.StringVarP(&f.flagCfg.Addr, "listen", "l", DefaultAddr, "Admission Controller listen address")
+	fs.StringVar(&f.flagCfg.Cert, "cert", "", "Admission Controller TLS cert")
+	fs.StringVar(&f.flagCfg.Key, "key", "", "Admission Controller TLS cert key")
+	fs.StringToStringVar(&f.modes, "mode", nil, "Admission func enforcement mode ('enforce', 'warn', 'audit' or 'dry-run'), e.g. /validating/check-deploy-time=warn")
+	fs.StringToStringVar(&f.timeouts, "timeout", nil, "Admission func timeout, e.g. /validating/check-deploy-time=2s")
+	fs.StringToStringVar(&f.timeoutFallbacks, "timeout-fallback", nil, "Admission func decision on timeout ('allow', 'deny', 'allow:<message>' or 'deny:<message>'), e.g. /validating/check-deploy-time=deny:deploy check timed out")
+	fs.StringToStringVar(&f.panicFallbacks, "panic-fallback", nil, "Admission func decision on panic ('allow', 'deny', 'allow:<message>' or 'deny:<message>'), e.g. /mutating/rename=allow")
+	fs.StringArrayVar(&f.pipelines, "pipeline", nil, "Mutating pipeline served at /mutating/pipeline/<name>, e.g. default=rename,disable-service-links")
+
+	// recording
+	fs.StringVar(&f.flagCfg.Record.Dir, "record-dir", "", "Directory to record the admission reviews as JSONL files with the sensitive values redacted, e.g. /var/lib/goadmission/records")
+	fs.Float64Var(&f.flagCfg.Record.SampleRate, "record-sample-rate", 1, "Fraction of the admission reviews recorded, between 0 and 1")
+	fs.StringSliceVar(&f.flagCfg.Record.Funcs, "record-func", nil, "Handle paths of the admission funcs and pipelines recorded, e.g. /mutating/rename, all by default")
+	fs.BoolVar(&f.flagCfg.Record.Responses, "record-responses", false, "Record the responses along with the requests")
+
+	// redaction
+	fs.BoolVar(&f.flagCfg.Redact.Disabled, "disable-redaction", false, "Log the admission reviews as they are at debug level, Secret data and sensitive environment variables are redacted by default")
+	fs.StringSliceVar(&f.flagCfg.Redact.Paths, "redact-path", nil, "JSON pointers of the extra values redacted in the logged and recorded objects, '*' matches any key or index, e.g. /spec/containers/*/args")
+
+	// adfunc image_rename
+	fs.StringSliceVar(&f.flagCfg.Rename.Rules, "image-rename", DefaultImageRenameRules, "Pod image name rename rules")
+	// adfunc check_deploy_time
+	fs.StringSliceVar(&f.flagCfg.CheckDeployTime.AllowTime, "allow-deploy-time", DefaultAllowDeployTime, "Allow deploy time")
+	fs.StringVar(&f.flagCfg.CheckDeployTime.ForceLabel, "force-deploy-label", DefaultForceDeployLabel, "Force deploy label")
+	// adfunc disable_service_links
+	fs.StringVar(&f.flagCfg.DisableServiceLinks.ForceEnableLabel, "force-enable-service-links-label", DefaultForceEnableServiceLinksLabel, "Force enable service links label")
+}
+
+// overrides applies the flag values to the config, keyed by flag name
+func (f *Flags) overrides() map[string]func(c *Config) error {
+	setFuncs := func(values map[string]string, update func(fc *FuncConfig, v string)) func(c *Config) error {
+		return func(c *Config) error {
+			for p, v := range values {
+				v := v
+				c.SetFunc(p, func(fc *FuncConfig) { update(fc, v) })
+			}
+			return nil
+		}
+	}
+	return map[string]func(c *Config) error{
+		"listen":       func(c *Config) error { c.Addr = f.flagCfg.Addr; return nil },
+		"cert":         func(c *Config) error { c.Cert = f.flagCfg.Cert; return nil },
+		"key":          func(c *Config) error { c.Key = f.flagCfg.Key; return nil },
+		"image-rename": func(c *Config) error { c.Rename.Rules = f.flagCfg.Rename.Rules; return nil },
+		"allow-deploy-time": func(c *Config) error {
+			c.CheckDeployTime.AllowTime = f.flagCfg.CheckDeployTime.AllowTime
+			return nil
+		},
+		"force-deploy-label": func(c *Config) error {
+			c.CheckDeployTime.ForceLabel = f.flagCfg.CheckDeployTime.ForceLabel
+			return nil
+		},
+		"force-enable-service-links-label": func(c *Config) error {
+			c.DisableServiceLinks.ForceEnableLabel = f.flagCfg.DisableServiceLinks.ForceEnableLabel
+			return nil
+		},
+		"mode":               setFuncs(f.modes, func(fc *FuncConfig, v string) { fc.Mode = v }),
+		"timeout":            setFuncs(f.timeouts, func(fc *FuncConfig, v string) { fc.Timeout = v }),
+		"timeout-fallback":   setFuncs(f.timeoutFallbacks, func(fc *FuncConfig, v string) { fc.TimeoutFallback = v }),
+		"panic-fallback":     setFuncs(f.panicFallbacks, func(fc *FuncConfig, v string) { fc.PanicFallback = v }),
+		"record-dir":         func(c *Config) error { c.Record.Dir = f.flagCfg.Record.Dir; return nil },
+		"record-sample-rate": func(c *Config) error { c.Record.SampleRate = f.flagCfg.Record.SampleRate; return nil },
+		"record-func":        func(c *Config) error { c.Record.Funcs = f.flagCfg.Record.Funcs; return nil },
+		"record-responses":   func(c *Config) error { c.Record.Responses = f.flagCfg.Record.Responses; return nil },
+		"disable-redaction":  func(c *Config) error { c.Redact.Disabled = f.flagCfg.Redact.Disabled; return nil },
+		"redact-path":        func(c *Config) error { c.Redact.Paths = f.flagCfg.Redact.Paths; return nil },
+		"pipeline": func(c *Config) error {
+			pipelines, err := ParsePipelines(f.pipelines)
+			if err != nil {
+				return err
+			}
+			c.Pipelines = pipelines
+			return nil
+		},
+	}
+}
+
+// WatchConfigMap starts watching the ConfigMap config source if --configmap is set,
+// the client is only created if it is set, a nil logger discards the logs. The informer is
+// stopped when ctx is done.
+func (f *Flags) WatchConfigMap(ctx context.Context, client func() (kubernetes.Interface, error), logger *zap.SugaredLogger) error {
+	if f.ConfigMap == "" {
+		return nil
+	}
+	namespace, name, err := ParseConfigMapRef(f.ConfigMap)
+	if err != nil {
+		return err
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	source := &ConfigMapSource{
+		Client:    c,
+		Namespace: namespace,
+		Name:      name,
+		Key:       f.ConfigMapKey,
+		Logger:    logger,
+	}
+	if err = source.Start(ctx); err != nil {
+		return err
+	}
+	f.source = source
+	return nil
+}
+
+// Load loads the config file, the watched ConfigMap and the environment variables,
+// then applies the flags set on the command line. The config is not validated.
+func (f *Flags) Load() (*Config, error) {
+	var overlays [][]byte
+	if f.source != nil {
+		data, err := f.source.Data()
+		if err != nil {
+			return nil, err
+		}
+		overlays = append(overlays, data)
+	}
+	c, err := Load(f.File, overlays...)
+	if err != nil {
+		return nil, err
+	}
+	if f.flags == nil {
+		return c, nil
+	}
+	overrides := f.overrides()
+	f.flags.VisitAll(func(flag *pflag.Flag) {
+		if apply, ok := overrides[flag.Name]; ok && flag.Changed && err == nil {
+			if applyErr := apply(c); applyErr != nil {
+				err = fmt.Errorf("--%s: %w", flag.Name, applyErr)
+			}
+		}
+	})
+	return c, err
+}
+
+// Watcher returns a Watcher that reloads the config by Load when the config file
+// or the watched ConfigMap changes, or SIGHUP is received.
+func (f *Flags) Watcher(interval time.Duration, apply func(c *Config) error, logger *zap.SugaredLogger) *Watcher {
+	w := &Watcher{
+		Path:     f.File,
+		Interval: interval,
+		Load:     f.Load,
+		Apply:    apply,
+		Logger:   logger,
+	}
+	if f.source != nil {
+		w.Changes = f.source.Changes()
+	}
+	return w
+}
@@ -0,0 +1,67 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestFlagsLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+listen: ":8000"
+cert: /etc/file.pem
+check_deploy_time:
+  force_label: file-label
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvPrefix+"CERT", "/etc/env.pem")
+	t.Setenv(EnvPrefix+"LISTEN", ":9000")
+
+	var f Flags
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	f.AddFlags(fs)
+	err = fs.Parse([]string{"--config", file, "--listen", ":10000", "--mode", "/mutating/rename=warn", "--pipeline", "default=rename"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := f.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Addr != ":10000" {
+		t.Errorf("listen = %s, want the flag value", c.Addr)
+	}
+	if c.Cert != "/etc/env.pem" {
+		t.Errorf("cert = %s, want the environment variable value", c.Cert)
+	}
+	if c.CheckDeployTime.ForceLabel != "file-label" {
+		t.Errorf("force_label = %s, want the config file value", c.CheckDeployTime.ForceLabel)
+	}
+	if c.Key != "" || len(c.Rename.Rules) != len(DefaultImageRenameRules) {
+		t.Errorf("unchanged flags override the config: key = %q, rename rules = %v", c.Key, c.Rename.Rules)
+	}
+	if c.Func("/mutating/rename").Mode != "warn" {
+		t.Errorf("mode = %q, want warn", c.Func("/mutating/rename").Mode)
+	}
+	if stages := c.Pipelines["default"]; len(stages) != 1 || stages[0] != "rename" {
+		t.Errorf("pipelines = %v, want default=rename", c.Pipelines)
+	}
+}
+
+func TestFlagsLoadInvalidPipeline(t *testing.T) {
+	var f Flags
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	f.AddFlags(fs)
+	if err := fs.Parse([]string{"--pipeline", "default"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Load(); err == nil {
+		t.Error("want the error of the invalid --pipeline")
+	}
+}
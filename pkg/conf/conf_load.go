@@ -0,0 +1,149 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the config file
+const EnvPrefix = "GOADMISSION_"
+
+// envOverrides maps the environment variables (without EnvPrefix) to the config,
+// lists are separated by ',' and pipelines are separated by ';'.
+var envOverrides = []struct {
+	name  string
+	apply func(c *Config, v string) error
+}{
+	{"LISTEN", func(c *Config, v string) error { c.Addr = v; return nil }},
+	{"CERT", func(c *Config, v string) error { c.Cert = v; return nil }},
+	{"KEY", func(c *Config, v string) error { c.Key = v; return nil }},
+	{"IMAGE_RENAME", func(c *Config, v string) error { c.Rename.Rules = splitList(v, ","); return nil }},
+	{"ALLOW_DEPLOY_TIME", func(c *Config, v string) error { c.CheckDeployTime.AllowTime = splitList(v, ","); return nil }},
+	{"FORCE_DEPLOY_LABEL", func(c *Config, v string) error { c.CheckDeployTime.ForceLabel = v; return nil }},
+	{"FORCE_ENABLE_SERVICE_LINKS_LABEL", func(c *Config, v string) error { c.DisableServiceLinks.ForceEnableLabel = v; return nil }},
+	{"MODE", func(c *Config, v string) error {
+		return c.applyFuncs(v, func(fc *FuncConfig, s string) { fc.Mode = s })
+	}},
+	{"TIMEOUT", func(c *Config, v string) error {
+		return c.applyFuncs(v, func(fc *FuncConfig, s string) { fc.Timeout = s })
+	}},
+	{"TIMEOUT_FALLBACK", func(c *Config, v string) error {
+		return c.applyFuncs(v, func(fc *FuncConfig, s string) { fc.TimeoutFallback = s })
+	}},
+	{"PANIC_FALLBACK", func(c *Config, v string) error {
+		return c.applyFuncs(v, func(fc *FuncConfig, s string) { fc.PanicFallback = s })
+	}},
+	{"PIPELINE", func(c *Config, v string) error {
+		pipelines, err := ParsePipelines(splitList(v, ";"))
+		if err != nil {
+			return err
+		}
+		c.Pipelines = pipelines
+		return nil
+	}},
+	{"RECORD_DIR", func(c *Config, v string) error { c.Record.Dir = v; return nil }},
+	{"RECORD_SAMPLE_RATE", func(c *Config, v string) error {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		c.Record.SampleRate = rate
+		return nil
+	}},
+	{"RECORD_FUNCS", func(c *Config, v string) error { c.Record.Funcs = splitList(v, ","); return nil }},
+	{"RECORD_RESPONSES", func(c *Config, v string) error {
+		responses, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Record.Responses = responses
+		return nil
+	}},
+	{"DISABLE_REDACTION", func(c *Config, v string) error {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Redact.Disabled = disabled
+		return nil
+	}},
+	{"REDACT_PATHS", func(c *Config, v string) error { c.Redact.Paths = splitList(v, ","); return nil }},
+}
+
+// Load returns the default config overridden by the config file, the overlays and the
+// environment variables in order, the config file is skipped if path is empty. Overlays
+// are YAML or JSON contents, e.g. the config of a ConfigMap. The config is not validated.
+func Load(path string, overlays ...[]byte) (*Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, bs := range overlays {
+		if err := c.LoadBytes(bs); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile overrides the config with the YAML or JSON config file, unknown fields are rejected
+func (c *Config) LoadFile(path string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	return c.LoadBytes(bs)
+}
+
+// LoadBytes overrides the config with the YAML or JSON content, unknown fields are rejected
+func (c *Config) LoadBytes(bs []byte) error {
+	if err := yaml.UnmarshalStrict(bs, c); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides the config with the GOADMISSION_* environment variables
+func (c *Config) ApplyEnv() error {
+	for _, o := range envOverrides {
+		v, ok := os.LookupEnv(EnvPrefix + o.name)
+		if !ok {
+			continue
+		}
+		if err := o.apply(c, v); err != nil {
+			return fmt.Errorf("%s%s: %w", EnvPrefix, o.name, err)
+		}
+	}
+	return nil
+}
+
+// applyFuncs parses "<handle path>=<value>,..." and updates the runtime settings of admission funcs
+func (c *Config) applyFuncs(v string, update func(fc *FuncConfig, s string)) error {
+	for _, kv := range splitList(v, ",") {
+		ss := strings.SplitN(kv, "=", 2)
+		if len(ss) != 2 || ss[0] == "" {
+			return fmt.Errorf("%s must be formatted as <handle path>=<value>", kv)
+		}
+		c.SetFunc(ss[0], func(fc *FuncConfig) { update(fc, ss[1]) })
+	}
+	return nil
+}
+
+func splitList(v, sep string) []string {
+	var ss []string
+	for _, s := range strings.Split(v, sep) {
+		if s = strings.TrimSpace(s); s != "" {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
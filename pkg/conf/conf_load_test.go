@@ -0,0 +1,151 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		check func(c *Config) interface{}
+		want  interface{}
+	}{
+		{"LISTEN", ":8443", func(c *Config) interface{} { return c.Addr }, ":8443"},
+		{"IMAGE_RENAME", "quay.io/=mirror.local/quay.io_, docker.io/=mirror.local/",
+			func(c *Config) interface{} { return c.Rename.Rules },
+			[]string{"quay.io/=mirror.local/quay.io_", "docker.io/=mirror.local/"}},
+		{"ALLOW_DEPLOY_TIME", "00:00~23:59", func(c *Config) interface{} { return c.CheckDeployTime.AllowTime }, []string{"00:00~23:59"}},
+		{"FORCE_DEPLOY_LABEL", "force.example.com", func(c *Config) interface{} { return c.CheckDeployTime.ForceLabel }, "force.example.com"},
+		{"MODE", "/mutating/rename=warn,/validating/check-deploy-time=audit",
+			func(c *Config) interface{} {
+				return []string{c.Func("/mutating/rename").Mode, c.Func("/validating/check-deploy-time").Mode}
+			},
+			[]string{"warn", "audit"}},
+		{"TIMEOUT", "/mutating/rename=2s", func(c *Config) interface{} { return c.Func("/mutating/rename").Timeout }, "2s"},
+		{"PIPELINE", "default=rename,disable-service-links;strict=rename",
+			func(c *Config) interface{} { return c.Pipelines },
+			map[string][]string{"default": {"rename", "disable-service-links"}, "strict": {"rename"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvPrefix+tt.name, tt.value)
+			c := Default()
+			if err := c.ApplyEnv(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.check(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s%s=%s: got %v, want %v", EnvPrefix, tt.name, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	t.Setenv(EnvPrefix+"MODE", "warn")
+	err := Default().ApplyEnv()
+	if err == nil || !strings.Contains(err.Error(), EnvPrefix+"MODE") {
+		t.Errorf("err = %v, want the error of %sMODE", err, EnvPrefix)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"yaml", "listen: \":8443\"\nrename:\n  rules:\n  - quay.io/=mirror.local/\n", ""},
+		{"json", `{"listen": ":8443", "rename": {"rules": ["quay.io/=mirror.local/"]}}`, ""},
+		{"unknown field", "listen: \":8443\"\nrenames:\n  rules: []\n", "unknown field"},
+		{"unknown nested field", "listen: \":8443\"\ncheck_deploy_time:\n  force_lable: force\n", "unknown field"},
+		{"wrong type", "listen: \":8443\"\nrename:\n  rules: quay.io/=mirror.local/\n", "failed to parse config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(file, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			c, err := Load(file)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Addr != ":8443" || !reflect.DeepEqual(c.Rename.Rules, []string{"quay.io/=mirror.local/"}) {
+				t.Errorf("config = %+v, want the values of the file", c)
+			}
+			if c.CheckDeployTime.ForceLabel != DefaultForceDeployLabel {
+				t.Errorf("force_label = %q, want the default value", c.CheckDeployTime.ForceLabel)
+			}
+		})
+	}
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("listen: \":8443\"\ncert: /etc/file.pem\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvPrefix+"LISTEN", ":9443")
+	c, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":9443" || c.Cert != "/etc/file.pem" {
+		t.Errorf("listen = %s, cert = %s, want the listen of the environment variable and the cert of the file", c.Addr, c.Cert)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(c *Config)
+		errs   []string
+	}{
+		{"default", func(c *Config) {}, nil},
+		{"rename rule", func(c *Config) { c.Rename.Rules = []string{"quay.io/"} }, []string{"rename.rules"}},
+		{"allow time", func(c *Config) { c.CheckDeployTime.AllowTime = []string{"05:00-10:00"} }, []string{"check_deploy_time.allow_time"}},
+		{"allow time clock", func(c *Config) { c.CheckDeployTime.AllowTime = []string{"05:00~25:00"} }, []string{"failed to parse allow time: 25:00"}},
+		{"labels", func(c *Config) {
+			c.CheckDeployTime.ForceLabel = ""
+			c.DisableServiceLinks.ForceEnableLabel = ""
+		}, []string{"check_deploy_time.force_label is empty", "disable_service_links.force_enable_label is empty"}},
+		{"pipeline", func(c *Config) { c.Pipelines["default"] = nil }, []string{"pipelines.default has no stage"}},
+		{"cert", func(c *Config) { c.Cert = "/etc/tls.pem" }, []string{"cert and key must be set together"}},
+		{"all errors", func(c *Config) {
+			c.Rename.Rules = []string{"="}
+			c.Key = "/etc/tls.key"
+		}, []string{"rename.rules", "cert and key must be set together"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.update(c)
+			err := c.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("err = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("err = nil, want %q", tt.errs)
+			}
+			for _, e := range tt.errs {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("err = %v, want %q", err, e)
+				}
+			}
+		})
+	}
+}
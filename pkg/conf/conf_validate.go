@@ -0,0 +1,75 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeLayout is the layout of the deploy time windows
+const TimeLayout = "15:04"
+
+// Validate checks the settings of the builtin admission funcs and pipelines, all
+// errors are returned. The settings that depend on the registered admission funcs
+// (e.g. Funcs) are checked by the dispatcher.
+func (c *Config) Validate() error {
+	var errs []error
+	for _, rule := range c.Rename.Rules {
+		if _, _, err := ParseRenameRule(rule); err != nil {
+			errs = append(errs, fmt.Errorf("rename.rules: %w", err))
+		}
+	}
+	for _, window := range c.CheckDeployTime.AllowTime {
+		if _, _, err := ParseTimeWindow(window); err != nil {
+			errs = append(errs, fmt.Errorf("check_deploy_time.allow_time: %w", err))
+		}
+	}
+	if c.CheckDeployTime.ForceLabel == "" {
+		errs = append(errs, errors.New("check_deploy_time.force_label is empty"))
+	}
+	if c.DisableServiceLinks.ForceEnableLabel == "" {
+		errs = append(errs, errors.New("disable_service_links.force_enable_label is empty"))
+	}
+	for name, stages := range c.Pipelines {
+		if len(stages) == 0 {
+			errs = append(errs, fmt.Errorf("pipelines.%s has no stage", name))
+		}
+	}
+	if c.Record.SampleRate < 0 || c.Record.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("record.sample_rate %v is not between 0 and 1", c.Record.SampleRate))
+	}
+	for _, p := range c.Redact.Paths {
+		if !strings.HasPrefix(p, "/") {
+			errs = append(errs, fmt.Errorf("redact.paths: %s is not a JSON pointer, e.g. /spec/containers/*/args", p))
+		}
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		errs = append(errs, errors.New("cert and key must be set together"))
+	}
+	return errors.Join(errs...)
+}
+
+// ParseRenameRule parses the image name rename rule, e.g. "k8s.gcr.io/=gcrxio/k8s.gcr.io_"
+func ParseRenameRule(rule string) (from, to string, err error) {
+	ss := strings.Split(rule, "=")
+	if len(ss) != 2 || ss[0] == "" {
+		return "", "", fmt.Errorf("failed to parse image name rename rule: %s", rule)
+	}
+	return ss[0], ss[1], nil
+}
+
+// ParseTimeWindow parses the deploy time window, e.g. "05:00~10:00"
+func ParseTimeWindow(window string) (start, end time.Time, err error) {
+	ss := strings.Split(window, "~")
+	if len(ss) != 2 {
+		return start, end, fmt.Errorf("allow time format is invalid: %s", window)
+	}
+	if start, err = time.Parse(TimeLayout, ss[0]); err != nil {
+		return start, end, fmt.Errorf("failed to parse allow time: %s: %w", ss[0], err)
+	}
+	if end, err = time.Parse(TimeLayout, ss[1]); err != nil {
+		return start, end, fmt.Errorf("failed to parse allow time: %s: %w", ss[1], err)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,110 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultWatchInterval is the default polling interval of the config file
+const DefaultWatchInterval = 5 * time.Second
+
+// Watcher reloads the config when the config file or another source changes or SIGHUP is received,
+// the current config is kept if the new config fails to load or apply.
+type Watcher struct {
+	// Path is the config file, it is polled by its content, so that the symlink
+	// swap of a mounted ConfigMap is detected. Only SIGHUP reloads if it is empty.
+	Path string
+	// Interval is the polling interval, DefaultWatchInterval is used if it is zero,
+	// and the config file is not polled if it is negative.
+	Interval time.Duration
+	// Changes signals that another config source is changed, e.g. ConfigMapSource.Changes()
+	Changes <-chan struct{}
+	// Load loads the new config
+	Load func() (*Config, error)
+	// Apply checks the new config and swaps it in
+	Apply  func(c *Config) error
+	Logger *zap.SugaredLogger
+}
+
+// Run watches the config until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	if w.Logger == nil {
+		w.Logger = zap.NewNop().Sugar()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if w.Path != "" && w.Interval >= 0 {
+		interval := w.Interval
+		if interval == 0 {
+			interval = DefaultWatchInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		w.Logger.Infof("watching config file %s every %s", w.Path, interval)
+	}
+
+	sum, _ := w.checksum()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if s, err := w.checksum(); err == nil {
+				sum = s
+			}
+			w.reload("SIGHUP")
+		case <-tick:
+			s, err := w.checksum()
+			if err != nil {
+				w.Logger.Warnf("failed to read config file %s: %v", w.Path, err)
+				continue
+			}
+			if bytes.Equal(s, sum) {
+				continue
+			}
+			sum = s
+			w.reload("config file changed")
+		case <-w.Changes:
+			w.reload("config source changed")
+		}
+	}
+}
+
+// reload loads and applies the new config, errors are logged
+func (w *Watcher) reload(reason string) {
+	w.Logger.Infof("reloading config: %s", reason)
+	c, err := w.Load()
+	if err != nil {
+		w.Logger.Errorf("failed to load config, the current config is kept: %v", err)
+		return
+	}
+	if err = w.Apply(c); err != nil {
+		w.Logger.Errorf("failed to apply config, the current config is kept: %v", err)
+		return
+	}
+	w.Logger.Info("config reloaded")
+}
+
+func (w *Watcher) checksum() ([]byte, error) {
+	if w.Path == "" {
+		return nil, nil
+	}
+	bs, err := os.ReadFile(w.Path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(bs)
+	return sum[:], nil
+}
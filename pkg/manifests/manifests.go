@@ -0,0 +1,307 @@
+package manifests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+)
+
+const (
+	// DefaultName is the default name of the webhook configurations, Service and Deployment
+	DefaultName = "goadmission"
+	// DefaultNamespace is the default namespace of the Service and Deployment
+	DefaultNamespace = "kube-addons"
+	// DefaultImage is the default image of the Deployment
+	DefaultImage = "mritd/goadmission"
+	// DefaultDomain is the default domain suffix of the webhook names
+	DefaultDomain = "mritd.com"
+	// DefaultTLSSecret is the default Secret of the TLS files, see "goadmission certs generate"
+	DefaultTLSSecret = "dynamic-admission-control-certs"
+
+	port     = 443
+	certsDir = "/etc/kubernetes/ssl"
+)
+
+// Options is the options of the generated manifests
+type Options struct {
+	Name      string
+	Namespace string
+	Image     string
+	Replicas  int32
+	// Domain is the domain suffix of the webhook names, e.g. "mutating-rename.goadmission.mritd.com"
+	Domain         string
+	FailurePolicy  admissionregistrationv1.FailurePolicyType
+	ServiceAccount string
+	// CABundle is the PEM encoded CA of the webhook configurations, it is left empty
+	// if ManageCerts is set, since the caBundle is injected at runtime.
+	CABundle []byte
+	// ManageCerts runs the Deployment with --manage-certs, otherwise the TLS files are mounted from TLSSecret
+	ManageCerts bool
+	TLSSecret   string
+	// Funcs is the names or handle paths of the admission funcs to generate webhooks for, e.g.
+	// "rename" or "/validating/print", a name matches the funcs of both types. The funcs that
+	// declare no Kinds have catch-all rules, so they are only generated if they are named.
+	Funcs []string
+}
+
+func (o *Options) complete() {
+	if o.Name == "" {
+		o.Name = DefaultName
+	}
+	if o.Namespace == "" {
+		o.Namespace = DefaultNamespace
+	}
+	if o.Image == "" {
+		o.Image = DefaultImage
+	}
+	if o.Replicas == 0 {
+		o.Replicas = 1
+	}
+	if o.Domain == "" {
+		o.Domain = DefaultDomain
+	}
+	if o.FailurePolicy == "" {
+		o.FailurePolicy = admissionregistrationv1.Ignore
+	}
+	if o.TLSSecret == "" {
+		o.TLSSecret = DefaultTLSSecret
+	}
+}
+
+// Generate returns the webhook configurations of the admission funcs in the registry, and
+// the Service and Deployment serving them. Every admission func has a webhook of its handle
+// path, rules, side effects and timeout.
+func Generate(registry *adfunc.Registry, opts Options) ([]runtime.Object, error) {
+	if err := registry.Err(); err != nil {
+		return nil, err
+	}
+	opts.complete()
+
+	labels := map[string]string{"app": opts.Name}
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Name, Labels: labels},
+	}
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "ValidatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Name, Labels: labels},
+	}
+
+	paths, _, err := Select(registry, opts.Funcs)
+	if err != nil {
+		return nil, err
+	}
+	funcs := registry.Funcs()
+	for _, p := range paths {
+		af := funcs[p]
+		path := p
+		sideEffects := af.SideEffectClass()
+		timeoutSeconds := af.TimeoutSeconds()
+		clientConfig := admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{
+				Namespace: opts.Namespace,
+				Name:      opts.Name,
+				Path:      &path,
+			},
+		}
+		if !opts.ManageCerts {
+			clientConfig.CABundle = opts.CABundle
+		}
+		name := WebhookName(p, opts.Name, opts.Domain)
+		failurePolicy := opts.FailurePolicy
+
+		switch af.Type {
+		case adfunc.AdmissionTypeMutating:
+			mutating.Webhooks = append(mutating.Webhooks, admissionregistrationv1.MutatingWebhook{
+				Name:                    name,
+				ClientConfig:            clientConfig,
+				Rules:                   af.Rules(),
+				FailurePolicy:           &failurePolicy,
+				NamespaceSelector:       excludeNamespace(opts.Namespace),
+				SideEffects:             &sideEffects,
+				TimeoutSeconds:          &timeoutSeconds,
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			})
+		case adfunc.AdmissionTypeValidating:
+			validating.Webhooks = append(validating.Webhooks, admissionregistrationv1.ValidatingWebhook{
+				Name:                    name,
+				ClientConfig:            clientConfig,
+				Rules:                   af.Rules(),
+				FailurePolicy:           &failurePolicy,
+				NamespaceSelector:       excludeNamespace(opts.Namespace),
+				SideEffects:             &sideEffects,
+				TimeoutSeconds:          &timeoutSeconds,
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			})
+		}
+	}
+
+	var objs []runtime.Object
+	if len(mutating.Webhooks) > 0 {
+		objs = append(objs, mutating)
+	}
+	if len(validating.Webhooks) > 0 {
+		objs = append(objs, validating)
+	}
+	return append(objs, service(opts, labels), deployment(opts, labels, len(mutating.Webhooks) > 0, len(validating.Webhooks) > 0)), nil
+}
+
+// Select returns the handle paths of the admission funcs to generate webhooks for by name, see
+// Options.Funcs, and the handle paths of the funcs skipped since they declare no Kinds. All
+// funcs that declare Kinds are selected if names is empty.
+func Select(registry *adfunc.Registry, names []string) (selected, skipped []string, err error) {
+	funcs := registry.Funcs()
+	if len(names) == 0 {
+		for _, p := range registry.Paths() {
+			if len(funcs[p].Kinds) == 0 {
+				skipped = append(skipped, p)
+				continue
+			}
+			selected = append(selected, p)
+		}
+		return selected, skipped, nil
+	}
+
+	named := make(map[string]bool, len(names))
+	var errs []error
+	for _, name := range names {
+		found := false
+		for _, typ := range []adfunc.AdmissionType{adfunc.AdmissionTypeMutating, adfunc.AdmissionTypeValidating} {
+			p := name
+			if !strings.HasPrefix(p, "/"+strings.ToLower(string(typ))+"/") {
+				if p, err = adfunc.HandlePath(adfunc.AdmissionFunc{Type: typ, Path: name}); err != nil {
+					continue
+				}
+			}
+			if af, ok := funcs[p]; ok && af.Type == typ {
+				named[p], found = true, true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("admission func [%s] is not registered", name))
+		}
+	}
+	for _, p := range registry.Paths() {
+		if named[p] {
+			selected = append(selected, p)
+		}
+	}
+	return selected, nil, errors.Join(errs...)
+}
+
+// WebhookName returns the webhook name of the handle path, e.g. "/mutating/rename" => "mutating-rename.goadmission.mritd.com"
+func WebhookName(handlePath, name, domain string) string {
+	return strings.ReplaceAll(strings.Trim(handlePath, "/"), "/", "-") + "." + name + "." + domain
+}
+
+// excludeNamespace returns the namespace selector that skips the namespace of the webhook,
+// so that the webhook does not block its own pods when it is unavailable.
+func excludeNamespace(namespace string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   []string{namespace},
+		}},
+	}
+}
+
+func service(opts Options, labels map[string]string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Name, Namespace: opts.Namespace, Labels: labels},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{{
+				Port:       port,
+				TargetPort: intstr.FromInt(port),
+			}},
+		},
+	}
+}
+
+func deployment(opts Options, labels map[string]string, mutating, validating bool) *appsv1.Deployment {
+	container := corev1.Container{
+		Name:  "goadmission",
+		Image: opts.Image,
+		Args:  []string{fmt.Sprintf("--listen=:%d", port)},
+		Ports: []corev1.ContainerPort{{Name: "https", ContainerPort: port}},
+	}
+	probe := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Scheme: corev1.URISchemeHTTPS, Port: intstr.FromInt(port), Path: "/healthz"},
+		},
+		PeriodSeconds:       10,
+		InitialDelaySeconds: 5,
+	}
+	container.LivenessProbe, container.ReadinessProbe = probe, probe.DeepCopy()
+
+	spec := corev1.PodSpec{ServiceAccountName: opts.ServiceAccount}
+	if opts.ManageCerts {
+		container.Args = append(container.Args,
+			"--manage-certs",
+			"--certs-namespace="+opts.Namespace,
+			"--certs-service="+opts.Name,
+		)
+		if mutating {
+			container.Args = append(container.Args, "--certs-mutating-webhook="+opts.Name)
+		}
+		if validating {
+			container.Args = append(container.Args, "--certs-validating-webhook="+opts.Name)
+		}
+	} else {
+		container.Args = append(container.Args,
+			"--cert="+certsDir+"/dac.pem",
+			"--key="+certsDir+"/dac-key.pem",
+		)
+		container.VolumeMounts = []corev1.VolumeMount{{Name: "certs", MountPath: certsDir, ReadOnly: true}}
+		spec.Volumes = []corev1.Volume{{
+			Name:         "certs",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: opts.TLSSecret}},
+		}}
+	}
+	spec.Containers = []corev1.Container{container}
+
+	replicas := opts.Replicas
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Name, Namespace: opts.Namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       spec,
+			},
+		},
+	}
+}
+
+// Write writes the objects as a multi-document YAML
+func Write(w io.Writer, objs []runtime.Object) error {
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		bs, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(bs)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
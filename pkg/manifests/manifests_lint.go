@@ -0,0 +1,301 @@
+package manifests
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+// Severity is the severity of a lint issue
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a problem of a webhook in a webhook configuration
+type Issue struct {
+	Severity Severity
+	// Object is the webhook configuration, e.g. "MutatingWebhookConfiguration/goadmission"
+	Object  string
+	Webhook string
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s: webhook %s: %s", i.Severity, i.Object, i.Webhook, i.Message)
+}
+
+// webhook is the fields of mutating and validating webhooks checked by Lint, the caBundle
+// is skipped since it is usually a placeholder, e.g. "${CA_BUNDLE}".
+type webhook struct {
+	Name              string                                       `json:"name"`
+	ClientConfig      clientConfig                                 `json:"clientConfig"`
+	Rules             []admissionregistrationv1.RuleWithOperations `json:"rules,omitempty"`
+	FailurePolicy     *admissionregistrationv1.FailurePolicyType   `json:"failurePolicy,omitempty"`
+	NamespaceSelector *metav1.LabelSelector                        `json:"namespaceSelector,omitempty"`
+	SideEffects       *admissionregistrationv1.SideEffectClass     `json:"sideEffects,omitempty"`
+	TimeoutSeconds    *int32                                       `json:"timeoutSeconds,omitempty"`
+}
+
+type clientConfig struct {
+	URL     *string                                   `json:"url,omitempty"`
+	Service *admissionregistrationv1.ServiceReference `json:"service,omitempty"`
+}
+
+type webhookConfiguration struct {
+	Webhooks []webhook `json:"webhooks"`
+}
+
+// Lint checks the webhooks of the Mutating/ValidatingWebhookConfiguration objects against the routes
+// of the admission funcs in the registry and the pipelines in the config, other objects are skipped.
+func Lint(registry *adfunc.Registry, cfg *conf.Config, objs []*unstructured.Unstructured) ([]Issue, error) {
+	funcs := registry.Funcs()
+	pipelines := make(map[string]bool, len(cfg.Pipelines))
+	for name := range cfg.Pipelines {
+		pipelines[adfunc.PipelinePath(name)] = true
+	}
+
+	var issues []Issue
+	for _, obj := range objs {
+		var typ adfunc.AdmissionType
+		switch obj.GroupVersionKind().GroupKind() {
+		case admissionregistrationv1.SchemeGroupVersion.WithKind("MutatingWebhookConfiguration").GroupKind():
+			typ = adfunc.AdmissionTypeMutating
+		case admissionregistrationv1.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration").GroupKind():
+			typ = adfunc.AdmissionTypeValidating
+		default:
+			continue
+		}
+		object := obj.GetKind() + "/" + obj.GetName()
+
+		var wc webhookConfiguration
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &wc); err != nil {
+			return nil, fmt.Errorf("%s: %w", object, err)
+		}
+		for _, wh := range wc.Webhooks {
+			for _, issue := range lintWebhook(typ, wh, funcs, pipelines, cfg) {
+				issue.Object, issue.Webhook = object, wh.Name
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues, nil
+}
+
+// lintWebhook checks the webhook of the typ webhook configuration
+func lintWebhook(typ adfunc.AdmissionType, wh webhook, funcs map[string]adfunc.AdmissionFunc, pipelines map[string]bool, cfg *conf.Config) []Issue {
+	var issues []Issue
+	errorf := func(format string, args ...interface{}) {
+		issues = append(issues, Issue{Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(format string, args ...interface{}) {
+		issues = append(issues, Issue{Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	ignored := ""
+	if wh.FailurePolicy != nil && *wh.FailurePolicy == admissionregistrationv1.Ignore {
+		ignored = ", the webhook is silently skipped since failurePolicy is Ignore"
+	}
+
+	path, namespace := "", ""
+	switch {
+	case wh.ClientConfig.Service != nil:
+		namespace = wh.ClientConfig.Service.Namespace
+		if wh.ClientConfig.Service.Path != nil {
+			path = *wh.ClientConfig.Service.Path
+		}
+	case wh.ClientConfig.URL != nil:
+		u, err := url.Parse(*wh.ClientConfig.URL)
+		if err != nil {
+			errorf("invalid url %s: %v", *wh.ClientConfig.URL, err)
+			return issues
+		}
+		path = u.Path
+	}
+
+	af, registered := funcs[path]
+	switch {
+	case path == "":
+		errorf("clientConfig has no path%s", ignored)
+		return issues
+	case pipelines[path]:
+		if typ != adfunc.AdmissionTypeMutating {
+			errorf("mutating pipeline %s is used in a validating webhook configuration", path)
+		}
+		af = adfunc.AdmissionFunc{Type: adfunc.AdmissionTypeMutating}
+	case !registered:
+		errorf("path %s is not a registered admission func%s", path, ignored)
+		return issues
+	case af.Type != typ:
+		errorf("%s admission func %s is used in a %s webhook configuration",
+			strings.ToLower(string(af.Type)), path, strings.ToLower(string(typ)))
+	}
+
+	// the requests out of the scope of the admission func are allowed without calling it
+	matched := false
+	for i, rule := range wh.Rules {
+		ops, resources := unhandledOperations(af, rule.Operations), unhandledResources(af, rule.Rule)
+		if len(ops) > 0 {
+			warnf("rules[%d] intercepts operations %s the admission func does not handle, they are allowed without calling it", i, strings.Join(ops, ","))
+		}
+		if len(resources) > 0 {
+			warnf("rules[%d] intercepts resources %s the admission func does not handle, they are allowed without calling it", i, strings.Join(resources, ","))
+		}
+		if interceptsOperation(af, rule.Operations) && interceptsResource(af, rule.Rule) {
+			matched = true
+		}
+	}
+	if len(wh.Rules) > 0 && !matched {
+		errorf("rules do not match any kind and operation the admission func handles, it is never called")
+	}
+
+	if t, err := time.ParseDuration(cfg.Func(path).Timeout); err == nil {
+		af.Timeout = t
+	}
+
+	// the webhook must declare the side effects of the admission func
+	want := af.SideEffectClass()
+	switch {
+	case wh.SideEffects == nil:
+		errorf("sideEffects is required, the admission func has side effects %s", want)
+	case hasSideEffects(want) && !hasSideEffects(*wh.SideEffects):
+		errorf("sideEffects %s is not supported, the admission func has side effects %s", *wh.SideEffects, want)
+	case hasSideEffects(*wh.SideEffects) && !hasSideEffects(want):
+		warnf("sideEffects %s rejects dry-run requests, the admission func has side effects %s", *wh.SideEffects, want)
+	}
+
+	timeoutSeconds := adfunc.DefaultTimeoutSeconds
+	if wh.TimeoutSeconds != nil {
+		timeoutSeconds = *wh.TimeoutSeconds
+	}
+	switch {
+	case timeoutSeconds < 1 || timeoutSeconds > adfunc.MaxTimeoutSeconds:
+		errorf("timeoutSeconds %d is not supported, it must be between 1 and %d", timeoutSeconds, adfunc.MaxTimeoutSeconds)
+	case timeoutSeconds < af.TimeoutSeconds() && af.Timeout > 0:
+		errorf("timeoutSeconds %d is shorter than the admission func needs (%d), the apiserver gives up before the timeout fallback", timeoutSeconds, af.TimeoutSeconds())
+	}
+
+	// the webhook must not intercept its own namespace, or its pods cannot be recreated when it is down
+	if namespace != "" {
+		ns := labels.Set{corev1.LabelMetadataName: namespace}
+		if wh.NamespaceSelector == nil {
+			errorf("namespaceSelector does not exclude the webhook namespace %s", namespace)
+		} else if selector, err := metav1.LabelSelectorAsSelector(wh.NamespaceSelector); err != nil {
+			errorf("invalid namespaceSelector: %v", err)
+		} else if selector.Matches(ns) {
+			errorf("namespaceSelector does not exclude the webhook namespace %s", namespace)
+		}
+	}
+	return issues
+}
+
+func hasSideEffects(c admissionregistrationv1.SideEffectClass) bool {
+	return c == admissionregistrationv1.SideEffectClassSome || c == admissionregistrationv1.SideEffectClassUnknown
+}
+
+// unhandledOperations returns the operations of the rule out of the scope of the admission func
+func unhandledOperations(af adfunc.AdmissionFunc, ops []admissionregistrationv1.OperationType) []string {
+	if len(af.Operations) == 0 {
+		return nil
+	}
+	var unhandled []string
+	for _, op := range ops {
+		if !handlesOperation(af, op) {
+			unhandled = append(unhandled, string(op))
+		}
+	}
+	return unhandled
+}
+
+// interceptsOperation reports whether the rule intercepts any operation handled by the admission func
+func interceptsOperation(af adfunc.AdmissionFunc, ops []admissionregistrationv1.OperationType) bool {
+	for _, op := range ops {
+		if op == admissionregistrationv1.OperationAll || len(af.Operations) == 0 || handlesOperation(af, op) {
+			return true
+		}
+	}
+	return false
+}
+
+func handlesOperation(af adfunc.AdmissionFunc, op admissionregistrationv1.OperationType) bool {
+	for _, o := range af.Operations {
+		if string(o) == string(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// unhandledResources returns the resources of the rule out of the scope of the admission func,
+// a resource is handled if the admission func handles it in all groups and versions of the rule.
+// The subresources are checked by the dispatcher.
+func unhandledResources(af adfunc.AdmissionFunc, rule admissionregistrationv1.Rule) []string {
+	var unhandled []string
+	for _, resource := range rule.Resources {
+		for _, gv := range groupVersions(rule) {
+			if !matchResource(af, gv, resource, false) {
+				unhandled = append(unhandled, resource)
+				break
+			}
+		}
+	}
+	return unhandled
+}
+
+// interceptsResource reports whether the rule intercepts any resource handled by the admission func
+func interceptsResource(af adfunc.AdmissionFunc, rule admissionregistrationv1.Rule) bool {
+	for _, resource := range rule.Resources {
+		for _, gv := range groupVersions(rule) {
+			if matchResource(af, gv, resource, true) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func groupVersions(rule admissionregistrationv1.Rule) []schema.GroupVersion {
+	var gvs []schema.GroupVersion
+	for _, g := range rule.APIGroups {
+		for _, v := range rule.APIVersions {
+			gvs = append(gvs, schema.GroupVersion{Group: g, Version: v})
+		}
+	}
+	return gvs
+}
+
+// matchResource reports whether the admission func handles the resource, "*" of the rule
+// only matches "*" of the admission func unless overlap is set.
+func matchResource(af adfunc.AdmissionFunc, gv schema.GroupVersion, resource string, overlap bool) bool {
+	if len(af.Kinds) == 0 {
+		return true
+	}
+	match := func(want, got string) bool { return want == "*" || want == got || overlap && got == "*" }
+	resource = strings.SplitN(resource, "/", 2)[0]
+	for _, gvk := range af.Kinds {
+		plural := "*"
+		if gvk.Kind != "*" {
+			gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+			plural = gvr.Resource
+		}
+		if match(gvk.Group, gv.Group) && match(gvk.Version, gv.Version) && match(plural, resource) {
+			return true
+		}
+	}
+	return false
+}
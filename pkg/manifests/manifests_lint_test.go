@@ -0,0 +1,164 @@
+package manifests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+func lintRegistry() *adfunc.Registry {
+	fn := func(context.Context, *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+		return adfunc.Allowed("success"), nil
+	}
+	pod := []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Pod")}
+	create := []admissionv1.Operation{admissionv1.Create}
+	registry := adfunc.NewRegistry()
+	registry.MustRegister(adfunc.AdmissionFunc{Type: adfunc.AdmissionTypeMutating, Path: "rename", Kinds: pod, Operations: create, Func: fn})
+	registry.MustRegister(adfunc.AdmissionFunc{Type: adfunc.AdmissionTypeValidating, Path: "check", Kinds: pod, Operations: create, Func: fn})
+	registry.MustRegister(adfunc.AdmissionFunc{Type: adfunc.AdmissionTypeValidating, Path: "audit", Kinds: pod,
+		SideEffects: admissionregistrationv1.SideEffectClassSome, Func: fn})
+	return registry
+}
+
+// lintObject returns a webhook configuration of the kind with a webhook calling the path,
+// the webhook is valid for the admission funcs of lintRegistry before it is updated.
+func lintObject(kind, path string, update func(wh map[string]interface{})) *unstructured.Unstructured {
+	wh := map[string]interface{}{
+		"name": "test.mritd.com",
+		"clientConfig": map[string]interface{}{
+			"service": map[string]interface{}{"name": "goadmission", "namespace": "kube-addons", "path": path},
+		},
+		"rules": []interface{}{map[string]interface{}{
+			"apiGroups":   []interface{}{""},
+			"apiVersions": []interface{}{"v1"},
+			"operations":  []interface{}{"CREATE"},
+			"resources":   []interface{}{"pods"},
+		}},
+		"failurePolicy":  "Fail",
+		"sideEffects":    "None",
+		"timeoutSeconds": int64(10),
+		"namespaceSelector": map[string]interface{}{
+			"matchExpressions": []interface{}{map[string]interface{}{
+				"key": corev1.LabelMetadataName, "operator": "NotIn", "values": []interface{}{"kube-addons"},
+			}},
+		},
+	}
+	if path == "/validating/audit" {
+		wh["sideEffects"] = "Some"
+	}
+	if update != nil {
+		update(wh)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "admissionregistration.k8s.io/v1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": "goadmission"},
+		"webhooks":   []interface{}{wh},
+	}}
+}
+
+func setRule(field string, values ...interface{}) func(wh map[string]interface{}) {
+	return func(wh map[string]interface{}) {
+		wh["rules"].([]interface{})[0].(map[string]interface{})[field] = values
+	}
+}
+
+func TestLint(t *testing.T) {
+	const mutating, validating = "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"
+	tests := []struct {
+		name   string
+		kind   string
+		path   string
+		update func(wh map[string]interface{})
+		// issues is the severity and a part of the message of the issues in order
+		issues []string
+	}{
+		{"mutating", mutating, "/mutating/rename", nil, nil},
+		{"validating", validating, "/validating/check", nil, nil},
+		{"side effects", validating, "/validating/audit", nil, nil},
+		{"missing path", mutating, "", nil, []string{"error: clientConfig has no path"}},
+		{"missing path ignored", mutating, "", func(wh map[string]interface{}) { wh["failurePolicy"] = "Ignore" },
+			[]string{"error: clientConfig has no path, the webhook is silently skipped"}},
+		{"unregistered path", mutating, "/mutating/renamed", nil,
+			[]string{"error: path /mutating/renamed is not a registered admission func"}},
+		{"mutating path in validating configuration", validating, "/mutating/rename", nil,
+			[]string{"error: mutating admission func /mutating/rename is used in a validating webhook configuration"}},
+		{"validating path in mutating configuration", mutating, "/validating/check", nil,
+			[]string{"error: validating admission func /validating/check is used in a mutating webhook configuration"}},
+		{"extra operation", mutating, "/mutating/rename", setRule("operations", "CREATE", "UPDATE"),
+			[]string{"warning: rules[0] intercepts operations UPDATE"}},
+		{"all operations", mutating, "/mutating/rename", setRule("operations", "*"),
+			[]string{"warning: rules[0] intercepts operations *"}},
+		{"operation mismatch", mutating, "/mutating/rename", setRule("operations", "DELETE"),
+			[]string{"warning: rules[0] intercepts operations DELETE", "error: rules do not match"}},
+		{"extra kind", mutating, "/mutating/rename", setRule("resources", "pods", "services"),
+			[]string{"warning: rules[0] intercepts resources services"}},
+		{"subresource", mutating, "/mutating/rename", setRule("resources", "pods/status"), nil},
+		{"kind mismatch", validating, "/validating/check", func(wh map[string]interface{}) {
+			setRule("apiGroups", "apps")(wh)
+			setRule("resources", "deployments")(wh)
+		}, []string{"warning: rules[0] intercepts resources deployments", "error: rules do not match"}},
+		{"all groups", validating, "/validating/check", setRule("apiGroups", "*"),
+			[]string{"warning: rules[0] intercepts resources pods"}},
+		{"missing side effects", mutating, "/mutating/rename", func(wh map[string]interface{}) { delete(wh, "sideEffects") },
+			[]string{"error: sideEffects is required"}},
+		{"side effects mismatch", validating, "/validating/audit", func(wh map[string]interface{}) { wh["sideEffects"] = "None" },
+			[]string{"error: sideEffects None is not supported, the admission func has side effects Some"}},
+		{"unexpected side effects", mutating, "/mutating/rename", func(wh map[string]interface{}) { wh["sideEffects"] = "Some" },
+			[]string{"warning: sideEffects Some rejects dry-run requests"}},
+		{"timeout", mutating, "/mutating/rename", func(wh map[string]interface{}) { wh["timeoutSeconds"] = int64(31) },
+			[]string{"error: timeoutSeconds 31 is not supported"}},
+		{"missing namespace selector", mutating, "/mutating/rename", func(wh map[string]interface{}) { delete(wh, "namespaceSelector") },
+			[]string{"error: namespaceSelector does not exclude the webhook namespace kube-addons"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := tt.update
+			if tt.path == "" {
+				update = func(wh map[string]interface{}) {
+					delete(wh["clientConfig"].(map[string]interface{})["service"].(map[string]interface{}), "path")
+					if tt.update != nil {
+						tt.update(wh)
+					}
+				}
+			}
+			issues, err := Lint(lintRegistry(), conf.Default(), []*unstructured.Unstructured{lintObject(tt.kind, tt.path, update)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != len(tt.issues) {
+				t.Fatalf("issues = %v, want %q", issues, tt.issues)
+			}
+			for i, issue := range issues {
+				msg := string(issue.Severity) + ": " + issue.Message
+				if !strings.HasPrefix(msg, tt.issues[i]) {
+					t.Errorf("issue %d = %q, want %q", i, msg, tt.issues[i])
+				}
+				if issue.Object != tt.kind+"/goadmission" || issue.Webhook != "test.mritd.com" {
+					t.Errorf("issue %d of %s, webhook %s, want the webhook of the configuration", i, issue.Object, issue.Webhook)
+				}
+			}
+		})
+	}
+}
+
+func TestLintSkipsOtherObjects(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": "goadmission"},
+	}}
+	issues, err := Lint(lintRegistry(), conf.Default(), []*unstructured.Unstructured{obj})
+	if err != nil || len(issues) != 0 {
+		t.Errorf("issues = %v, %v, want the Service skipped", issues, err)
+	}
+}
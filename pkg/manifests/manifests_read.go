@@ -0,0 +1,175 @@
+package manifests
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// Document is a document of a multi-document YAML stream
+type Document struct {
+	// Raw is the document as it is read
+	Raw []byte
+	// Object is the object of the document, it is nil if the document has no kind
+	Object *unstructured.Unstructured
+	// List is the list of the document (e.g. the output of "kubectl get -o yaml"), Object is nil then
+	List *unstructured.UnstructuredList
+}
+
+// Objects returns the object of the document, or the items of the list
+func (doc Document) Objects() []*unstructured.Unstructured {
+	if doc.Object != nil {
+		return []*unstructured.Unstructured{doc.Object}
+	}
+	if doc.List == nil {
+		return nil
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(doc.List.Items))
+	for i := range doc.List.Items {
+		objs = append(objs, &doc.List.Items[i])
+	}
+	return objs
+}
+
+// ReadDocuments reads the documents of a multi-document YAML stream, e.g. the manifests
+// rendered by Helm. Unlike Read, the lists are not flattened and the documents without
+// kind (e.g. comments) are kept, so that the stream can be written back as it is.
+func ReadDocuments(r io.Reader) ([]Document, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	var docs []Document
+	for {
+		raw, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		bs, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		doc := Document{Raw: raw}
+		if bytes.Equal(bytes.TrimSpace(bs), []byte("null")) {
+			docs = append(docs, doc)
+			continue
+		}
+
+		obj, _, err := unstructured.UnstructuredJSONScheme.Decode(bs, nil, nil)
+		if runtime.IsMissingKind(err) {
+			docs = append(docs, doc)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		switch o := obj.(type) {
+		case *unstructured.Unstructured:
+			doc.Object = o
+		case *unstructured.UnstructuredList:
+			doc.List = o
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// Read reads the objects of a multi-document YAML or JSON stream, the items of lists
+// (e.g. the output of "kubectl get -o yaml") are flattened, and the documents without
+// kind are skipped.
+func Read(r io.Reader) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objs []*unstructured.Unstructured
+	for {
+		var raw runtime.RawExtension
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		raw.Raw = bytes.TrimSpace(raw.Raw)
+		if len(raw.Raw) == 0 || bytes.Equal(raw.Raw, []byte("null")) {
+			continue
+		}
+
+		obj, _, err := unstructured.UnstructuredJSONScheme.Decode(raw.Raw, nil, nil)
+		if runtime.IsMissingKind(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		switch o := obj.(type) {
+		case *unstructured.Unstructured:
+			objs = append(objs, o)
+		case *unstructured.UnstructuredList:
+			for i := range o.Items {
+				objs = append(objs, &o.Items[i])
+			}
+		}
+	}
+}
+
+// ReadFiles reads the objects of the files, the *.yaml, *.yml and *.json files in
+// directories are read recursively, and "-" is stdin.
+func ReadFiles(paths []string, stdin io.Reader) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	for _, p := range paths {
+		if p == "-" {
+			read, err := Read(stdin)
+			if err != nil {
+				return nil, fmt.Errorf("stdin: %w", err)
+			}
+			objs = append(objs, read...)
+			continue
+		}
+		err := filepath.WalkDir(p, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if path != p && !isManifest(path) {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			read, err := Read(f)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			objs = append(objs, read...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+func isManifest(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package manifests
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+)
+
+func testRegistry() *adfunc.Registry {
+	fn := func(context.Context, *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+		return adfunc.Allowed("success"), nil
+	}
+	pod := []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Pod")}
+	registry := adfunc.NewRegistry()
+	registry.MustRegister(adfunc.AdmissionFunc{Type: adfunc.AdmissionTypeMutating, Path: "/rename", Kinds: pod, Func: fn})
+	registry.MustRegister(adfunc.AdmissionFunc{Type: adfunc.AdmissionTypeMutating, Path: "/print", Func: fn})
+	registry.MustRegister(adfunc.AdmissionFunc{Type: adfunc.AdmissionTypeValidating, Path: "/print", Func: fn})
+	return registry
+}
+
+// webhookPaths returns the service paths of the generated webhooks
+func webhookPaths(t *testing.T, opts Options) []string {
+	t.Helper()
+	objs, err := Generate(testRegistry(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, obj := range objs {
+		switch obj := obj.(type) {
+		case *admissionregistrationv1.MutatingWebhookConfiguration:
+			for _, w := range obj.Webhooks {
+				paths = append(paths, *w.ClientConfig.Service.Path)
+			}
+		case *admissionregistrationv1.ValidatingWebhookConfiguration:
+			for _, w := range obj.Webhooks {
+				paths = append(paths, *w.ClientConfig.Service.Path)
+			}
+		}
+	}
+	return paths
+}
+
+func TestGenerateFuncs(t *testing.T) {
+	tests := []struct {
+		name  string
+		funcs []string
+		paths []string
+	}{
+		{"funcs without kinds are skipped", nil, []string{"/mutating/rename"}},
+		{"named by name", []string{"print"}, []string{"/mutating/print", "/validating/print"}},
+		{"named by handle path", []string{"/validating/print", "rename"}, []string{"/mutating/rename", "/validating/print"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if paths := webhookPaths(t, Options{Funcs: tt.funcs}); !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("webhook paths = %v, want %v", paths, tt.paths)
+			}
+		})
+	}
+
+	if _, err := Generate(testRegistry(), Options{Funcs: []string{"unknown"}}); err == nil {
+		t.Error("unknown func is generated")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	selected, skipped, err := Select(testRegistry(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(selected, []string{"/mutating/rename"}) {
+		t.Errorf("selected = %v, want the funcs declaring kinds", selected)
+	}
+	if !reflect.DeepEqual(skipped, []string{"/mutating/print", "/validating/print"}) {
+		t.Errorf("skipped = %v, want the funcs declaring no kinds", skipped)
+	}
+}
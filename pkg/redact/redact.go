@@ -0,0 +1,332 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Mask replaces the redacted values
+const Mask = "******"
+
+// maskedJSON replaces the JSON content that can not be redacted
+var maskedJSON = []byte(`"` + Mask + `"`)
+
+// lastAppliedAnnotation is the annotation of kubectl apply that copies the whole object
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// SensitiveEnvNames is the case-insensitive substrings of the environment variable names
+// whose values are redacted, e.g. "DB_PASSWORD" and "GITHUB_TOKEN".
+var SensitiveEnvNames = []string{
+	"PASSWORD",
+	"PASSWD",
+	"SECRET",
+	"TOKEN",
+	"CREDENTIAL",
+	"API_KEY",
+	"APIKEY",
+	"ACCESS_KEY",
+	"PRIVATE_KEY",
+	"AUTH",
+}
+
+// SensitiveEnv reports whether the value of the environment variable is redacted
+func SensitiveEnv(name string) bool {
+	name = strings.ToUpper(name)
+	for _, s := range SensitiveEnvNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor redacts the sensitive values of admission reviews, objects and JSON patches: the
+// values of Secret data and stringData, the values of the sensitive environment variables in
+// any container, the values of the extra paths, and the same values in the
+// last-applied-configuration annotation. The keys are kept.
+type Redactor struct {
+	nop   bool
+	paths [][]string
+}
+
+// New returns a Redactor with the extra paths, a path is a JSON pointer into the objects
+// where "*" matches any key or index, e.g. "/spec/containers/*/args".
+func New(paths ...string) *Redactor {
+	r := &Redactor{}
+	for _, p := range paths {
+		r.paths = append(r.paths, segments(p))
+	}
+	return r
+}
+
+// Nop returns a Redactor that redacts nothing
+func Nop() *Redactor {
+	return &Redactor{nop: true}
+}
+
+// segments splits the JSON pointer into the unescaped reference tokens
+func segments(pointer string) []string {
+	if pointer == "" || pointer == "/" {
+		return nil
+	}
+	ss := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, s := range ss {
+		ss[i] = strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+	}
+	return ss
+}
+
+// Object redacts the object in place
+func (r *Redactor) Object(obj map[string]interface{}) {
+	if r.nop || obj == nil {
+		return
+	}
+	if kind, _ := obj["kind"].(string); kind == "Secret" {
+		for _, field := range []string{"data", "stringData"} {
+			if data, ok := obj[field].(map[string]interface{}); ok {
+				for k := range data {
+					data[k] = Mask
+				}
+			}
+		}
+	}
+	redactEnv(obj)
+	for _, p := range r.paths {
+		maskPath(obj, p)
+	}
+
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if applied, ok := annotations[lastAppliedAnnotation].(string); ok {
+		annotations[lastAppliedAnnotation] = r.objectJSON([]byte(applied))
+	}
+}
+
+// redactEnv masks the values of the sensitive environment variables in the "env" lists of v
+func redactEnv(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if env, ok := child.([]interface{}); ok && k == "env" {
+				for _, e := range env {
+					e, ok := e.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					if name, _ := e["name"].(string); SensitiveEnv(name) {
+						if _, ok := e["value"]; ok {
+							e["value"] = Mask
+						}
+					}
+				}
+				continue
+			}
+			redactEnv(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			redactEnv(child)
+		}
+	}
+}
+
+// maskPath masks the values of v at the path, it returns the value replacing v
+func maskPath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return Mask
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if path[0] == "*" || path[0] == k {
+				v[k] = maskPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				v[i] = maskPath(child, path[1:])
+			}
+		}
+	}
+	return v
+}
+
+// Request redacts the objects of the AdmissionRequest in JSON, it is masked if it is not a JSON object
+func (r *Redactor) Request(bs []byte) []byte {
+	if r.nop {
+		return bs
+	}
+	var request map[string]interface{}
+	if err := unmarshal(bs, &request); err != nil {
+		return maskedJSON
+	}
+	r.request(request)
+	return marshal(request)
+}
+
+// request redacts the objects of the request and returns the kind of the request
+func (r *Redactor) request(request map[string]interface{}) string {
+	for _, field := range []string{"object", "oldObject"} {
+		if obj, ok := request[field].(map[string]interface{}); ok {
+			r.Object(obj)
+		}
+	}
+	gvk, _ := request["kind"].(map[string]interface{})
+	kind, _ := gvk["kind"].(string)
+	return kind
+}
+
+// Review redacts the objects of the request and the patch of the response of the AdmissionReview
+// in JSON, both admission.k8s.io/v1 and v1beta1 are supported. The whole review is masked if it
+// is not a JSON object.
+func (r *Redactor) Review(bs []byte) []byte {
+	if r.nop {
+		return bs
+	}
+	var review map[string]interface{}
+	if err := unmarshal(bs, &review); err != nil {
+		return maskedJSON
+	}
+	kind := ""
+	if request, ok := review["request"].(map[string]interface{}); ok {
+		kind = r.request(request)
+	}
+	r.response(review, kind)
+	return marshal(review)
+}
+
+// Response redacts the patch of the response of the AdmissionReview in JSON, kind is the
+// kind of the request object, e.g. "Secret". The whole review is masked if it is not a
+// JSON object.
+func (r *Redactor) Response(bs []byte, kind string) []byte {
+	if r.nop {
+		return bs
+	}
+	var review map[string]interface{}
+	if err := unmarshal(bs, &review); err != nil {
+		return maskedJSON
+	}
+	r.response(review, kind)
+	return marshal(review)
+}
+
+// response redacts the base64 encoded patch of the response of the review
+func (r *Redactor) response(review map[string]interface{}, kind string) {
+	response, _ := review["response"].(map[string]interface{})
+	patch, ok := response["patch"].(string)
+	if !ok || patch == "" {
+		return
+	}
+	decoded, err := base64.StdEncoding.DecodeString(patch)
+	if err != nil {
+		response["patch"] = Mask
+		return
+	}
+	response["patch"] = base64.StdEncoding.EncodeToString(r.Patch(decoded, kind))
+}
+
+// Patch redacts the values of the JSON patch of the kind object: the values of Secret data
+// and stringData, the environment variable values set by path since their names are unknown,
+// the values of the extra paths, and the sensitive values of the added objects and lists.
+func (r *Redactor) Patch(patch []byte, kind string) []byte {
+	if r.nop || len(patch) == 0 {
+		return patch
+	}
+	var ops []map[string]interface{}
+	if err := unmarshal(patch, &ops); err != nil {
+		return maskedJSON
+	}
+	for _, op := range ops {
+		path, _ := op["path"].(string)
+		value, ok := op["value"]
+		if !ok {
+			continue
+		}
+		segs := segments(path)
+		last := ""
+		if len(segs) > 0 {
+			last = segs[len(segs)-1]
+		}
+		switch {
+		case kind == "Secret" && len(segs) > 0 && (segs[0] == "data" || segs[0] == "stringData"):
+			op["value"] = maskValues(value)
+			continue
+		case len(segs) > 2 && segs[len(segs)-3] == "env" && last == "value":
+			op["value"] = Mask
+			continue
+		case len(segs) > 1 && segs[len(segs)-2] == "env":
+			// an environment variable is added to the list
+			redactEnv(map[string]interface{}{"env": []interface{}{value}})
+		case len(segs) == 0:
+			if obj, ok := value.(map[string]interface{}); ok {
+				r.Object(obj)
+			}
+		default:
+			redactEnv(map[string]interface{}{last: value})
+		}
+		for _, p := range r.paths {
+			if !matchPrefix(segs, p) {
+				continue
+			}
+			if len(p) <= len(segs) {
+				value = Mask
+			} else {
+				value = maskPath(value, p[len(segs):])
+			}
+		}
+		op["value"] = value
+	}
+	return marshal(ops)
+}
+
+// matchPrefix reports whether the shorter one of the path and the pattern matches the
+// other, "-" of the path (the end of an array) matches any index.
+func matchPrefix(path, pattern []string) bool {
+	for i := 0; i < len(path) && i < len(pattern); i++ {
+		if pattern[i] != "*" && path[i] != "-" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// maskValues masks v, or the values of v if it is a map
+func maskValues(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return Mask
+	}
+	for k := range m {
+		m[k] = Mask
+	}
+	return m
+}
+
+// objectJSON redacts the JSON object, the content is masked if it is not a JSON object
+func (r *Redactor) objectJSON(bs []byte) string {
+	var obj map[string]interface{}
+	if err := unmarshal(bs, &obj); err != nil {
+		return Mask
+	}
+	r.Object(obj)
+	return string(marshal(obj))
+}
+
+// unmarshal decodes the JSON with the numbers kept as they are
+func unmarshal(bs []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func marshal(v interface{}) []byte {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return maskedJSON
+	}
+	return bs
+}
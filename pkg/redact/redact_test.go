@@ -0,0 +1,323 @@
+package redact
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lookup returns the value of the JSON pointer in the JSON document
+func lookup(t *testing.T, bs []byte, pointer string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(bs, &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", bs, err)
+	}
+	for _, token := range segments(pointer) {
+		switch cur := v.(type) {
+		case map[string]interface{}:
+			v = cur[token]
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i >= len(cur) {
+				return nil
+			}
+			v = cur[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// check checks the values of the JSON pointers and that the secrets are not left in the JSON
+func check(t *testing.T, bs []byte, want map[string]interface{}, secrets []string) {
+	t.Helper()
+	for pointer, value := range want {
+		if got := lookup(t, bs, pointer); got != value {
+			t.Errorf("%s = %v, want %v in %s", pointer, got, value, bs)
+		}
+	}
+	for _, s := range secrets {
+		if strings.Contains(string(bs), s) {
+			t.Errorf("%q is not redacted: %s", s, bs)
+		}
+	}
+}
+
+const secretObject = `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"db","annotations":{
+"kubectl.kubernetes.io/last-applied-configuration":"{\"apiVersion\":\"v1\",\"kind\":\"Secret\",\"stringData\":{\"password\":\"hunter2\"}}\n"}},
+"data":{"password":"aHVudGVyMg=="},"stringData":{"token":"s3cr3t"}}`
+
+const podObject = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"app","annotations":{"example.com/token":"t0ken","example.com/name":"app",
+"kubectl.kubernetes.io/last-applied-configuration":"{\"kind\":\"Pod\",\"spec\":{\"containers\":[{\"env\":[{\"name\":\"DB_PASSWORD\",\"value\":\"applied-pw\"}]}]}}"}},
+"spec":{"containers":[{"name":"app","image":"app:1.0","args":["--password=hunter2"],
+"env":[{"name":"DB_PASSWORD","value":"hunter2"},{"name":"LOG_LEVEL","value":"debug"},
+{"name":"API_TOKEN","valueFrom":{"secretKeyRef":{"name":"api","key":"token"}}}]}]}}`
+
+func review(apiVersion, kind, object, patch string) string {
+	bs := `{"apiVersion":"admission.k8s.io/` + apiVersion + `","kind":"AdmissionReview","request":{"uid":"1",
+"kind":{"group":"","version":"v1","kind":"` + kind + `"},"object":` + object + `,"oldObject":` + object + `}`
+	if patch != "" {
+		bs += `,"response":{"uid":"1","allowed":true,"patchType":"JSONPatch","patch":"` + base64.StdEncoding.EncodeToString([]byte(patch)) + `"}`
+	}
+	return bs + "}"
+}
+
+func TestReview(t *testing.T) {
+	tests := []struct {
+		name     string
+		redactor *Redactor
+		review   string
+		want     map[string]interface{}
+		patch    map[string]interface{}
+		secrets  []string
+	}{
+		{
+			name:     "secret",
+			redactor: New(),
+			review:   review("v1", "Secret", secretObject, ""),
+			want: map[string]interface{}{
+				"/request/object/data/password":       Mask,
+				"/request/object/stringData/token":    Mask,
+				"/request/oldObject/data/password":    Mask,
+				"/request/oldObject/stringData/token": Mask,
+				"/request/object/metadata/name":       "db",
+			},
+			secrets: []string{"aHVudGVyMg==", "s3cr3t", "hunter2"},
+		},
+		{
+			name:     "sensitive env",
+			redactor: New(),
+			review:   review("v1beta1", "Pod", podObject, ""),
+			want: map[string]interface{}{
+				"/apiVersion": "admission.k8s.io/v1beta1",
+				"/request/object/spec/containers/0/env/0/name":                       "DB_PASSWORD",
+				"/request/object/spec/containers/0/env/0/value":                      Mask,
+				"/request/object/spec/containers/0/env/1/value":                      "debug",
+				"/request/object/spec/containers/0/env/2/valueFrom/secretKeyRef/key": "token",
+				"/request/object/spec/containers/0/args/0":                           "--password=hunter2",
+				"/request/object/metadata/annotations/example.com~1token":            "t0ken",
+				"/request/oldObject/spec/containers/0/env/0/value":                   Mask,
+			},
+			secrets: []string{"applied-pw"},
+		},
+		{
+			name:     "extra paths with * and ~1",
+			redactor: New("/spec/containers/*/args", "/metadata/annotations/example.com~1token"),
+			review:   review("v1", "Pod", podObject, ""),
+			want: map[string]interface{}{
+				"/request/object/spec/containers/0/args":                     Mask,
+				"/request/object/metadata/annotations/example.com~1token":    Mask,
+				"/request/object/metadata/annotations/example.com~1name":     "app",
+				"/request/oldObject/spec/containers/0/args":                  Mask,
+				"/request/oldObject/metadata/annotations/example.com~1token": Mask,
+			},
+			secrets: []string{"hunter2", "t0ken"},
+		},
+		{
+			name:     "response patch",
+			redactor: New(),
+			review:   review("v1", "Secret", secretObject, `[{"op":"replace","path":"/data/password","value":"bmV3"}]`),
+			want: map[string]interface{}{
+				"/response/patchType": "JSONPatch",
+				"/response/uid":       "1",
+			},
+			patch:   map[string]interface{}{"/0/path": "/data/password", "/0/value": Mask},
+			secrets: []string{"bmV3"},
+		},
+		{
+			name:     "nop",
+			redactor: Nop(),
+			review:   review("v1", "Secret", secretObject, ""),
+			want: map[string]interface{}{
+				"/request/object/data/password":    "aHVudGVyMg==",
+				"/request/object/stringData/token": "s3cr3t",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bs := tt.redactor.Review([]byte(tt.review))
+			check(t, bs, tt.want, tt.secrets)
+
+			if tt.patch != nil {
+				encoded, _ := lookup(t, bs, "/response/patch").(string)
+				decoded, err := base64.StdEncoding.DecodeString(encoded)
+				if err != nil {
+					t.Fatalf("patch is not base64 encoded: %v", err)
+				}
+				check(t, decoded, tt.patch, tt.secrets)
+			}
+		})
+	}
+}
+
+func TestReviewInvalid(t *testing.T) {
+	if bs := New().Review([]byte(`{"request":`)); string(bs) != `"`+Mask+`"` {
+		t.Errorf("invalid review = %s, want it masked", bs)
+	}
+	if bs := New().Response([]byte(`[]`), "Secret"); string(bs) != `"`+Mask+`"` {
+		t.Errorf("invalid response = %s, want it masked", bs)
+	}
+	bs := New().Response([]byte(`{"response":{"patch":"not base64!"}}`), "Secret")
+	check(t, bs, map[string]interface{}{"/response/patch": Mask}, []string{"not base64!"})
+}
+
+func TestRequest(t *testing.T) {
+	request := `{"uid":"1","kind":{"group":"","version":"v1","kind":"Secret"},"object":` + secretObject + `}`
+	bs := New().Request([]byte(request))
+	check(t, bs, map[string]interface{}{
+		"/uid":                     "1",
+		"/object/data/password":    Mask,
+		"/object/stringData/token": Mask,
+	}, []string{"aHVudGVyMg==", "s3cr3t", "hunter2"})
+}
+
+func TestResponse(t *testing.T) {
+	patch := `[{"op":"add","path":"/spec/containers/0/env/-","value":{"name":"DB_PASSWORD","value":"hunter2"}},
+{"op":"replace","path":"/spec/containers/0/image","value":"app:2.0"}]`
+	resp := `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","response":{"uid":"1","allowed":true,
+"patchType":"JSONPatch","patch":"` + base64.StdEncoding.EncodeToString([]byte(patch)) + `"}}`
+
+	bs := New().Response([]byte(resp), "Pod")
+	check(t, bs, map[string]interface{}{"/response/uid": "1", "/response/patchType": "JSONPatch"}, nil)
+	encoded, _ := lookup(t, bs, "/response/patch").(string)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("patch is not base64 encoded: %v", err)
+	}
+	check(t, decoded, map[string]interface{}{
+		"/0/value/name":  "DB_PASSWORD",
+		"/0/value/value": Mask,
+		"/1/value":       "app:2.0",
+	}, []string{"hunter2"})
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		redactor *Redactor
+		kind     string
+		patch    string
+		want     map[string]interface{}
+		secrets  []string
+	}{
+		{
+			name:    "secret data",
+			kind:    "Secret",
+			patch:   `[{"op":"replace","path":"/data/password","value":"bmV3"},{"op":"add","path":"/stringData","value":{"token":"s3cr3t"}}]`,
+			want:    map[string]interface{}{"/0/value": Mask, "/1/value/token": Mask},
+			secrets: []string{"bmV3", "s3cr3t"},
+		},
+		{
+			name:  "data of other kinds",
+			kind:  "ConfigMap",
+			patch: `[{"op":"replace","path":"/data/level","value":"debug"}]`,
+			want:  map[string]interface{}{"/0/value": "debug"},
+		},
+		{
+			name:    "env value by path",
+			kind:    "Pod",
+			patch:   `[{"op":"replace","path":"/spec/containers/0/env/1/value","value":"hunter2"}]`,
+			want:    map[string]interface{}{"/0/value": Mask},
+			secrets: []string{"hunter2"},
+		},
+		{
+			name:  "env appended by -",
+			kind:  "Pod",
+			patch: `[{"op":"add","path":"/spec/containers/0/env/-","value":{"name":"API_TOKEN","value":"t0ken"}},{"op":"add","path":"/spec/containers/0/env/-","value":{"name":"LOG_LEVEL","value":"debug"}}]`,
+			want: map[string]interface{}{
+				"/0/value/value": Mask,
+				"/1/value/value": "debug",
+			},
+			secrets: []string{"t0ken"},
+		},
+		{
+			name:    "env list",
+			kind:    "Pod",
+			patch:   `[{"op":"add","path":"/spec/containers/0/env","value":[{"name":"DB_PASSWORD","value":"hunter2"}]}]`,
+			want:    map[string]interface{}{"/0/value/0/value": Mask, "/0/value/0/name": "DB_PASSWORD"},
+			secrets: []string{"hunter2"},
+		},
+		{
+			name:     "extra path with *",
+			redactor: New("/spec/containers/*/args"),
+			kind:     "Pod",
+			patch: `[{"op":"add","path":"/spec/containers/0/args","value":["--password=hunter2"]},
+{"op":"add","path":"/spec/containers/-","value":{"name":"sidecar","args":["--token=t0ken"]}},
+{"op":"add","path":"/spec/containers/1/image","value":"sidecar:1.0"}]`,
+			want: map[string]interface{}{
+				"/0/value":      Mask,
+				"/1/value/args": Mask,
+				"/1/value/name": "sidecar",
+				"/2/value":      "sidecar:1.0",
+			},
+			secrets: []string{"hunter2", "t0ken"},
+		},
+		{
+			name:     "extra path with ~1",
+			redactor: New("/metadata/annotations/example.com~1token"),
+			kind:     "Pod",
+			patch: `[{"op":"add","path":"/metadata/annotations/example.com~1token","value":"t0ken"},
+{"op":"add","path":"/metadata/annotations/example.com~1name","value":"app"},
+{"op":"add","path":"/metadata/annotations","value":{"example.com/token":"t0ken"}}]`,
+			want: map[string]interface{}{
+				"/0/value":                    Mask,
+				"/1/value":                    "app",
+				"/2/value/example.com~1token": Mask,
+			},
+			secrets: []string{"t0ken"},
+		},
+		{
+			name:    "whole object",
+			kind:    "Secret",
+			patch:   `[{"op":"replace","path":"","value":` + secretObject + `}]`,
+			want:    map[string]interface{}{"/0/value/data/password": Mask, "/0/value/metadata/name": "db"},
+			secrets: []string{"aHVudGVyMg==", "s3cr3t", "hunter2"},
+		},
+		{
+			name:  "remove",
+			kind:  "Secret",
+			patch: `[{"op":"remove","path":"/data/password"}]`,
+			want:  map[string]interface{}{"/0/op": "remove", "/0/path": "/data/password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor := tt.redactor
+			if redactor == nil {
+				redactor = New()
+			}
+			check(t, redactor.Patch([]byte(tt.patch), tt.kind), tt.want, tt.secrets)
+		})
+	}
+
+	if bs := New().Patch(nil, "Secret"); bs != nil {
+		t.Errorf("empty patch = %s, want it as it is", bs)
+	}
+	if bs := New().Patch([]byte(`{}`), "Secret"); string(bs) != `"`+Mask+`"` {
+		t.Errorf("invalid patch = %s, want it masked", bs)
+	}
+	patch := `[{"op":"replace","path":"/data/password","value":"bmV3"}]`
+	if bs := Nop().Patch([]byte(patch), "Secret"); string(bs) != patch {
+		t.Errorf("Nop().Patch() = %s, want it as it is", bs)
+	}
+}
+
+func TestSensitiveEnv(t *testing.T) {
+	for name, want := range map[string]bool{
+		"DB_PASSWORD":  true,
+		"github_token": true,
+		"AWS_SECRET":   true,
+		"AUTH_HEADER":  true,
+		"LOG_LEVEL":    false,
+		"HOME":         false,
+	} {
+		if got := SensitiveEnv(name); got != want {
+			t.Errorf("SensitiveEnv(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -6,23 +6,10 @@ import (
 	"sort"
 )
 
-func init() {
-	RegisterHandler(HandleFunc{
-		Path:   "/",
-		Method: http.MethodGet,
-		Func:   available,
-	})
-	RegisterHandler(HandleFunc{
-		Path:   "/available",
-		Method: http.MethodGet,
-		Func:   available,
-	})
-}
-
-func available(w http.ResponseWriter, _ *http.Request) {
+func (rt *Router) available(w http.ResponseWriter, _ *http.Request) {
 	_, _ = fmt.Fprint(w, "## AvailableRoutes\n\n")
-	keys := make([]string, 0, len(funcMap))
-	for k := range funcMap {
+	keys := make([]string, 0, len(rt.funcs))
+	for k := range rt.funcs {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
@@ -5,10 +5,8 @@ import (
 	"net/http"
 	"runtime/debug"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/mritd/goadmission/pkg/zaplogger"
 	"go.uber.org/zap"
 
 	"github.com/gorilla/mux"
@@ -27,77 +25,102 @@ type HandleFunc struct {
 
 type handleFuncMap map[string]HandleFunc
 
-var funcMap = make(handleFuncMap, 10)
-var routerOnce sync.Once
-var logger *zap.SugaredLogger
-
-func RegisterHandler(hf HandleFunc) {
+func (m handleFuncMap) register(hf HandleFunc) error {
 	if hf.Path == "" {
-		logger.Fatalf("handle func path is empty")
+		return fmt.Errorf("handle func path is empty")
 	}
-	registeredHf, ok := funcMap[strings.ToLower(hf.Path)]
+	registeredHf, ok := m[strings.ToLower(hf.Path)]
 	if ok && registeredHf.Method == hf.Method {
-		logger.Fatalf("handle func [%s] already registered", hf.Path)
+		return fmt.Errorf("handle func [%s] already registered", hf.Path)
 	}
-	funcMap[strings.ToLower(hf.Path)] = hf
+	m[strings.ToLower(hf.Path)] = hf
+	return nil
 }
 
-func ResponseErr(handlePath, msg string, httpCode int, w http.ResponseWriter) {
-	logger.Errorf("handle func [%s] response err: %s", handlePath, msg)
-	review := &admissionv1.AdmissionReview{
-		Response: &admissionv1.AdmissionResponse{
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: msg,
-			},
-		},
+// funcMap is a collection of global handle funcs, they are served by every Router
+var funcMap = make(handleFuncMap, 10)
+
+// RegisterHandler registers a global handle func, it is usually called in package init
+func RegisterHandler(hf HandleFunc) {
+	if err := funcMap.register(hf); err != nil {
+		panic(err)
 	}
-	bs, err := jsoniter.Marshal(review)
-	if err != nil {
-		logger.Errorf("failed to marshal response: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf("failed to marshal response: %s", err)))
+}
+
+// Router is a http router that serves the global handle funcs and its own handle funcs
+type Router struct {
+	logger *zap.SugaredLogger
+	funcs  handleFuncMap
+}
+
+// NewRouter returns a Router that contains all global handle funcs
+func NewRouter(logger *zap.SugaredLogger) *Router {
+	rt := &Router{
+		logger: logger,
+		funcs:  make(handleFuncMap, len(funcMap)+10),
 	}
+	for p, hf := range funcMap {
+		rt.funcs[p] = hf
+	}
+	rt.funcs["/"] = HandleFunc{Path: "/", Method: http.MethodGet, Func: rt.available}
+	rt.funcs["/available"] = HandleFunc{Path: "/available", Method: http.MethodGet, Func: rt.available}
+	return rt
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(httpCode)
-	_, err = w.Write(bs)
-	logger.Debugf("write err response: %d: %v: %v", httpCode, review, err)
+// RegisterHandler registers a handle func that is only served by this Router
+func (rt *Router) RegisterHandler(hf HandleFunc) error {
+	return rt.funcs.register(hf)
+}
+
+// Handler builds the http handler of all registered handle funcs
+func (rt *Router) Handler() http.Handler {
+	rt.logger.Info("init http router...")
+	router := mux.NewRouter().StrictSlash(true)
+	for p, f := range rt.funcs {
+		rt.logger.Infof("load handle func: %s", p)
+		router.HandleFunc(f.Path, f.Func).Methods(f.Method)
+	}
+	return rt.loggingMiddleware()(router)
 }
 
-func loggingMiddleware() func(http.Handler) http.Handler {
+func (rt *Router) loggingMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
 					w.WriteHeader(http.StatusInternalServerError)
-					logger.Errorf("err: %v, trace: %s", err, string(debug.Stack()))
+					rt.logger.Errorf("err: %v, trace: %s", err, string(debug.Stack()))
 				}
 			}()
 
 			start := time.Now()
 			next.ServeHTTP(w, r)
-			logger.Debugf("received request: %s %s %s", time.Since(start), strings.ToLower(r.Method), r.URL.EscapedPath())
+			rt.logger.Debugf("received request: %s %s %s", time.Since(start), strings.ToLower(r.Method), r.URL.EscapedPath())
 		}
 		return http.HandlerFunc(fn)
 	}
 }
 
-var router *mux.Router
-
-func Setup() {
-	routerOnce.Do(func() {
-		logger = zaplogger.NewSugar("route")
-
-		logger.Info("init global http router...")
-		router = mux.NewRouter().StrictSlash(true)
-		for p, f := range funcMap {
-			logger.Infof("load handle func: %s", p)
-			router.HandleFunc(f.Path, f.Func).Methods(f.Method)
-		}
-	})
-}
+func ResponseErr(logger *zap.SugaredLogger, handlePath, msg string, httpCode int, w http.ResponseWriter) {
+	logger.Errorf("handle func [%s] response err: %s", handlePath, msg)
+	review := &admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Message: msg,
+			},
+		},
+	}
+	bs, err := jsoniter.Marshal(review)
+	if err != nil {
+		logger.Errorf("failed to marshal response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("failed to marshal response: %s", err)))
+		return
+	}
 
-func Router() http.Handler {
-	return loggingMiddleware()(router)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	_, err = w.Write(bs)
+	logger.Debugf("write err response: %d: %v: %v", httpCode, review, err)
 }
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/certs"
+	"github.com/mritd/goadmission/pkg/conf"
+	"github.com/mritd/goadmission/pkg/route"
+)
+
+// Server is an admission control webhook server, several servers can run in one
+// process since every server has its own router, registry, config and logger.
+type Server struct {
+	addr     string
+	cert     string
+	key      string
+	logger   *zap.Logger
+	registry *adfunc.Registry
+	getCert  func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	// configMu serializes Reload, config is the last applied config
+	configMu sync.Mutex
+	config   *conf.Config
+
+	dispatcher *adfunc.Dispatcher
+	handler    http.Handler
+	srv        *http.Server
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAddr sets the listen address, it overrides the address of the config
+func WithAddr(addr string) Option {
+	return func(s *Server) {
+		s.addr = addr
+	}
+}
+
+// WithTLS sets the TLS cert and key files, it overrides the files of the config.
+// The files are reloaded when they change.
+func WithTLS(cert, key string) Option {
+	return func(s *Server) {
+		s.cert = cert
+		s.key = key
+	}
+}
+
+// WithCertificate sets the serving certificate source, e.g. certs.Manager.GetCertificate,
+// it overrides the TLS files.
+func WithCertificate(getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)) Option {
+	return func(s *Server) {
+		s.getCert = getCert
+	}
+}
+
+// WithLogger sets the logger, logs are discarded by default
+func WithLogger(logger *zap.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithRegistry sets the admission func registry, adfunc.DefaultRegistry is used by default
+func WithRegistry(registry *adfunc.Registry) Option {
+	return func(s *Server) {
+		s.registry = registry
+	}
+}
+
+// WithConfig sets the config, conf.Default() is used by default
+func WithConfig(config *conf.Config) Option {
+	return func(s *Server) {
+		s.config = config
+	}
+}
+
+// New creates a Server and routes the admission funcs of its registry
+func New(opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.config == nil {
+		s.config = conf.Default()
+	}
+	if s.addr == "" {
+		s.addr = s.config.Addr
+	}
+	if s.cert == "" && s.key == "" {
+		s.cert, s.key = s.config.Cert, s.config.Key
+	}
+	if s.logger == nil {
+		s.logger = zap.NewNop()
+	}
+	if s.registry == nil {
+		s.registry = adfunc.DefaultRegistry
+	}
+
+	router := route.NewRouter(s.logger.Named("route").Sugar())
+	s.dispatcher = adfunc.NewDispatcher(s.registry, s.config, s.logger.Named("adfunc").Sugar())
+	if err := s.dispatcher.Setup(router); err != nil {
+		return nil, err
+	}
+	s.handler = router.Handler()
+	s.srv = &http.Server{
+		Handler: s.handler,
+		Addr:    s.addr,
+	}
+	if s.getCert != nil {
+		s.srv.TLSConfig = &tls.Config{GetCertificate: s.getCert}
+	} else if s.cert != "" && s.key != "" {
+		reloader, err := certs.NewKeyPairReloader(s.cert, s.key, s.logger.Named("certs").Sugar())
+		if err != nil {
+			return nil, err
+		}
+		s.srv.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+	}
+	return s, nil
+}
+
+// Handler returns the http handler of the server, it can be mounted to
+// another http server or used with httptest.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Config returns the current config of the server
+func (s *Server) Config() *conf.Config {
+	return s.dispatcher.Config()
+}
+
+// Reload checks the config and swaps it in without restarting the server, the current
+// config is kept if the config is invalid. The listen address and TLS file paths only
+// take effect after a restart, while the content of the TLS files is reloaded when it changes.
+func (s *Server) Reload(config *conf.Config) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if err := s.dispatcher.Reload(config); err != nil {
+		return err
+	}
+	if config.Addr != s.config.Addr || config.Cert != s.config.Cert || config.Key != s.config.Key {
+		s.logger.Sugar().Warn("the listen address or TLS file paths are changed, restart the server to apply them")
+	}
+	s.config = config
+	return nil
+}
+
+// Run starts the server and blocks until ctx is done, then the server is gracefully shutdown
+// and the recorded admission reviews are flushed
+func (s *Server) Run(ctx context.Context) error {
+	logger := s.logger.Named("server").Sugar()
+	defer s.dispatcher.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		if s.srv.TLSConfig != nil {
+			logger.Infof("Listen TLS Server at %s", s.addr)
+			errCh <- s.srv.ListenAndServeTLS("", "")
+		} else {
+			logger.Infof("Listen HTTP Server at %s", s.addr)
+			errCh <- s.srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		logger.Warn("Receiving the termination signal, graceful shutdown...")
+		if err := s.srv.Shutdown(context.Background()); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		logger.Info("server shutdown success.")
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/mritd/goadmission/pkg/adfunc"
+	"github.com/mritd/goadmission/pkg/conf"
+)
+
+func TestReload(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	srv, err := New(WithRegistry(adfunc.NewRegistry()), WithLogger(zap.New(core)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listen := conf.Default()
+	listen.Addr = ":8444"
+	if err = srv.Reload(listen); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Config() != listen || srv.config != listen {
+		t.Error("reloaded config is not stored")
+	}
+	if logs.FilterMessageSnippet("restart the server").Len() != 1 {
+		t.Errorf("want a warning of the changed listen address, got %v", logs.All())
+	}
+
+	// the warning is not repeated once the changed listen address is stored
+	rules := conf.Default()
+	rules.Addr = ":8444"
+	rules.Rename.Rules = []string{"docker.io/=mirror.local/"}
+	if err = srv.Reload(rules); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Config() != rules || srv.config != rules {
+		t.Error("reloaded config is not stored")
+	}
+	if logs.FilterMessageSnippet("restart the server").Len() != 1 {
+		t.Errorf("want no more warnings, got %v", logs.All())
+	}
+
+	invalid := conf.Default()
+	invalid.CheckDeployTime.AllowTime = []string{"bad"}
+	if err = srv.Reload(invalid); err == nil {
+		t.Error("invalid config is reloaded")
+	}
+	if srv.Config() != rules || srv.config != rules {
+		t.Error("current config is not kept after the invalid config")
+	}
+}
+
+// labelRegistry returns a registry of "/validating/<path>" that denies requests with
+// the force deploy label of the config serving the request
+func labelRegistry(path string) *adfunc.Registry {
+	registry := adfunc.NewRegistry()
+	registry.MustRegister(adfunc.AdmissionFunc{
+		Type: adfunc.AdmissionTypeValidating,
+		Path: path,
+		Func: func(ctx context.Context, _ *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+			return adfunc.Denied(http.StatusForbidden, conf.FromContext(ctx).CheckDeployTime.ForceLabel), nil
+		},
+	})
+	return registry
+}
+
+// review posts an AdmissionReview of the pod to the handle path and returns the response
+func review(t *testing.T, handler http.Handler, path, image string) (int, *admissionv1.AdmissionResponse) {
+	t.Helper()
+	pod, err := json.Marshal(corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: image}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "e911857d-c318-11e8-bbad-025000000001",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Name:      "test",
+			Namespace: "default",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: pod},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	var resp admissionv1.AdmissionReview
+	if err = json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Response == nil {
+		t.Fatalf("failed to decode the response %s: %v", rec.Body, err)
+	}
+	return rec.Code, resp.Response
+}
+
+func TestServersIsolated(t *testing.T) {
+	configA, configB := conf.Default(), conf.Default()
+	configA.CheckDeployTime.ForceLabel = "force-a"
+	configB.CheckDeployTime.ForceLabel = "force-b"
+	registryA, registryB := labelRegistry("a"), labelRegistry("b")
+	a, err := New(WithRegistry(registryA), WithConfig(configA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(WithRegistry(registryB), WithConfig(configB))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		path    string
+		code    int
+		message string
+	}{
+		{"a serves its func", a.Handler(), "/validating/a", http.StatusOK, "force-a"},
+		{"a does not serve the func of b", a.Handler(), "/validating/b", http.StatusNotFound, ""},
+		{"b serves its func", b.Handler(), "/validating/b", http.StatusOK, "force-b"},
+		{"b does not serve the func of a", b.Handler(), "/validating/a", http.StatusNotFound, ""},
+		{"b does not serve the default registry", b.Handler(), "/mutating/rename", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := review(t, tt.handler, tt.path, "nginx")
+			if code != tt.code {
+				t.Fatalf("http code = %d, want %d", code, tt.code)
+			}
+			if resp != nil && (resp.Result == nil || resp.Result.Message != tt.message) {
+				t.Errorf("response = %v, want the label of the config %s", resp, tt.message)
+			}
+		})
+	}
+}
+
+func TestReloadServed(t *testing.T) {
+	srv, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, resp := review(t, srv.Handler(), "/mutating/rename", "k8s.gcr.io/pause:3.6")
+	if !strings.Contains(string(resp.Patch), "gcrxio/k8s.gcr.io_pause:3.6") {
+		t.Fatalf("patch = %s, want the image renamed by the default rules", resp.Patch)
+	}
+
+	config := conf.Default()
+	config.Rename.Rules = []string{"k8s.gcr.io/=mirror.local/"}
+	if err = srv.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	_, resp = review(t, srv.Handler(), "/mutating/rename", "k8s.gcr.io/pause:3.6")
+	if !strings.Contains(string(resp.Patch), "mirror.local/pause:3.6") {
+		t.Errorf("patch = %s, want the image renamed by the reloaded rules", resp.Patch)
+	}
+	// the config of the other server is not reloaded
+	_, resp = review(t, other.Handler(), "/mutating/rename", "k8s.gcr.io/pause:3.6")
+	if !strings.Contains(string(resp.Patch), "gcrxio/k8s.gcr.io_pause:3.6") {
+		t.Errorf("patch = %s, want the image renamed by the default rules", resp.Patch)
+	}
+}
@@ -14,13 +14,14 @@ var confOnce sync.Once
 
 func NewLogger(c *zapConfig) *zap.Logger {
 	syncer := zapcore.AddSync(os.Stdout)
+	opts := append([]zap.Option(nil), c.opts...)
 	if c.sample {
-		c.opts = append(c.opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
 			return zapcore.NewSamplerWithOptions(core, time.Second, 100, 100)
 		}))
 	}
-	c.opts = append(c.opts, zap.AddCallerSkip(1), zap.ErrorOutput(syncer))
-	return zap.New(zapcore.NewCore(c.encoder, syncer, c.level)).WithOptions(c.opts...)
+	opts = append(opts, zap.AddCallerSkip(1), zap.ErrorOutput(syncer))
+	return zap.New(zapcore.NewCore(c.encoder, syncer, c.level)).WithOptions(opts...)
 }
 
 func New(name string) *zap.Logger {